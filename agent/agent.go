@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"ibp-geodns/config"
+	"ibp-geodns/consensus"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval       = 10
+	defaultTimeout        = 5
+	defaultResyncInterval = 60 * time.Second
+)
+
+type server struct {
+	url     string
+	mu      sync.Mutex
+	failing bool
+}
+
+type received struct {
+	result config.CheckResult
+	seen   time.Time
+}
+
+// Run pushes the latest result of every check read from results to the
+// ingestion URL of each configured server every interval, until results is
+// closed.
+func Run(cfg *config.Config, results chan []config.CheckResult) {
+	settings := cfg.Agent
+	interval := settings.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	timeout := settings.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	resyncInterval := defaultResyncInterval
+	if cfg.ResultsResyncInterval > 0 {
+		resyncInterval = time.Duration(cfg.ResultsResyncInterval) * time.Second
+	}
+
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	servers := make([]*server, 0, len(settings.Servers))
+	for _, url := range settings.Servers {
+		servers = append(servers, &server{url: url})
+	}
+	log.Printf("Agent %s pushing results to %d servers every %d seconds", settings.Name, len(servers), interval)
+
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	latest := make(map[string]received)
+	for {
+		select {
+		case batch, ok := <-results:
+			if !ok {
+				return
+			}
+			now := time.Now()
+			for _, result := range batch {
+				latest[result.MemberName+" "+result.EndpointURL+"::"+result.CheckName] = received{result: result, seen: now}
+			}
+		case <-ticker.C:
+			// The monitor resends all results every resync interval, results
+			// it stopped sending belong to removed members.
+			pushed := consensus.AgentResults{Agent: settings.Name, Interval: interval, Results: make([]config.CheckResult, 0, len(latest))}
+			for key, entry := range latest {
+				if time.Since(entry.seen) > 3*resyncInterval {
+					delete(latest, key)
+					continue
+				}
+				result := entry.result
+				result.Data = nil
+				pushed.Results = append(pushed.Results, result)
+			}
+
+			body, err := json.Marshal(pushed)
+			if err != nil {
+				log.Printf("Failed to encode results: %v", err)
+				continue
+			}
+			for _, s := range servers {
+				go s.push(client, settings, body)
+			}
+		}
+	}
+}
+
+// push sends body to the server, signed with the key of the agent over the
+// current time and the body.
+func (s *server) push(client *http.Client, settings *config.Agent, body []byte) {
+	err := func() error {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(consensus.AgentHeader, settings.Name)
+		req.Header.Set(consensus.TimestampHeader, timestamp)
+		req.Header.Set(consensus.SignatureHeader, consensus.Sign(settings.Key, timestamp+"\n"+string(body)))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && !s.failing {
+		log.Printf("Failed to push results to %s: %v", s.url, err)
+	} else if err == nil && s.failing {
+		log.Printf("Pushing results to %s again", s.url)
+	}
+	s.failing = err != nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"ibp-geodns/config"
+	"ibp-geodns/ibpmonitor"
+	"ibp-geodns/powerdns"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+)
+
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func loadJSONFile(filename string, target interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// loadRemoteConfiguration loads the service configuration file and fetches the
+// members and services it points to, the same way serve does at startup.
+func loadRemoteConfiguration(configPath string) *config.Config {
+	configfile, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	done := make(chan bool)
+	config.Init(done, configfile)
+	<-done
+
+	return configfile
+}
+
+func printJSON(value interface{}) {
+	output, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode output: %v", err)
+	}
+	fmt.Println(string(output))
+}
+
+func validate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	membersFile := flags.String("members", "members_professional.json", "members configuration file")
+	servicesFile := flags.String("services", "services_rpc.json", "services configuration file")
+	flags.Parse(args)
+
+	var members map[string]config.Member
+	if err := loadJSONFile(*membersFile, &members); err != nil {
+		log.Fatalf("Failed to load members from %s: %v", *membersFile, err)
+	}
+
+	var services map[string]config.Service
+	if err := loadJSONFile(*servicesFile, &services); err != nil {
+		log.Fatalf("Failed to load services from %s: %v", *servicesFile, err)
+	}
+
+	report := config.Validate(members, services)
+	for _, issue := range report.Errors {
+		fmt.Printf("ERROR   %s %s %s: %s\n", issue.Kind, issue.Name, issue.Field, issue.Message)
+	}
+	for _, issue := range report.Warnings {
+		fmt.Printf("WARNING %s %s %s: %s\n", issue.Kind, issue.Name, issue.Field, issue.Message)
+	}
+	fmt.Printf("%d errors, %d warnings\n", len(report.Errors), len(report.Warnings))
+
+	if report.HasErrors() {
+		os.Exit(1)
+	}
+}
+
+func validateConfig(args []string) {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configPath := flags.String("config", envOrDefault("GEODNS_CONFIG", "config.json"), "configuration file (env GEODNS_CONFIG)")
+	flags.Parse(args)
+
+	configfile, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	problems := []string{}
+	if configfile.ServerName == "" {
+		problems = append(problems, "ServerName is empty")
+	}
+	for field, value := range map[string]string{
+		"MembersConfigUrl":   configfile.MembersConfigUrl,
+		"ServicesConfigUrl":  configfile.ServicesConfigUrl,
+		"StaticDNSConfigUrl": configfile.StaticDNSConfigUrl,
+	} {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s '%s' is not a valid URL", field, value))
+		}
+	}
+	if _, err := os.Stat(configfile.GeoliteDBPath); err != nil {
+		problems = append(problems, fmt.Sprintf("GeoliteDBPath: %v", err))
+	}
+	if configfile.Matrix == nil {
+		problems = append(problems, "Matrix section is missing")
+	}
+	if configfile.Signatures != nil && configfile.Signatures.Enabled == 1 && len(configfile.Signatures.TrustedKeys) < configfile.Signatures.Threshold {
+		problems = append(problems, "Signatures.Threshold is higher than the number of trusted keys")
+	}
+	for checkName, checkConfig := range configfile.Checks {
+		if _, exists := ibpmonitor.LookupCheck(checkName, checkConfig); !exists {
+			problems = append(problems, fmt.Sprintf("check '%s' is not a known check and has no Command", checkName))
+		}
+		if checkConfig.CheckType != "site" && checkConfig.CheckType != "endpoint" {
+			problems = append(problems, fmt.Sprintf("check '%s' has invalid CheckType '%s'", checkName, checkConfig.CheckType))
+		}
+		if checkConfig.Enabled == 1 && (checkConfig.Timeout <= 0 || checkConfig.CheckInterval <= 0) {
+			problems = append(problems, fmt.Sprintf("check '%s' needs a positive Timeout and CheckInterval", checkName))
+		}
+	}
+
+	sort.Strings(problems)
+	for _, problem := range problems {
+		fmt.Printf("ERROR   %s\n", problem)
+	}
+	fmt.Printf("%d errors\n", len(problems))
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func resolve(args []string) {
+	flags := flag.NewFlagSet("resolve", flag.ExitOnError)
+	configPath := flags.String("config", envOrDefault("GEODNS_CONFIG", "config.json"), "configuration file (env GEODNS_CONFIG)")
+	qtype := flags.String("qtype", "A", "query type")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s resolve [options] <domain> <client-ip>\n\nAll members are assumed healthy, no checks are run.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	configfile := loadRemoteConfiguration(*configPath)
+	endpoints, _, _ := config.ExtractData()
+	powerdns.Load(buildPowerDNSConfigs(endpoints), configfile)
+
+	printJSON(powerdns.Lookup(flags.Arg(0), *qtype, flags.Arg(1)))
+}
+
+func check(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := flags.String("config", envOrDefault("GEODNS_CONFIG", "config.json"), "configuration file (env GEODNS_CONFIG)")
+	checkName := flags.String("check", "", "only run this check (default: all enabled checks)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s check [options] <member|member@site>\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	memberName := flags.Arg(0)
+
+	configfile := loadRemoteConfiguration(*configPath)
+	_, memberServices, serviceEndpoints := config.ExtractData()
+
+	// A member name selects all of its sites, a site key a single one.
+	members := []ibpmonitor.Member{}
+	for _, candidate := range buildMonitorMembers(memberServices, serviceEndpoints) {
+		if candidate.MemberName == memberName || candidate.ID() == memberName {
+			members = append(members, candidate)
+		}
+	}
+	if len(members) == 0 {
+		log.Fatalf("Member '%s' not found or not active", memberName)
+	}
+
+	checkNames := []string{}
+	for name, checkConfig := range configfile.Checks {
+		if (*checkName == "" && checkConfig.Enabled == 1) || name == *checkName {
+			checkNames = append(checkNames, name)
+		}
+	}
+	if len(checkNames) == 0 {
+		log.Fatalf("No matching checks configured")
+	}
+	sort.Strings(checkNames)
+
+	failed := false
+	for _, member := range members {
+		for _, name := range checkNames {
+			results, err := ibpmonitor.RunCheck(name, member, configfile.Checks[name])
+			if err != nil {
+				log.Fatalf("Failed to run check %s: %v", name, err)
+			}
+			for _, result := range results {
+				if !result.Success {
+					failed = true
+				}
+				printJSON(result)
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -3,67 +3,203 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	MembersURL  string
-	ServicesURL string
-	Members     map[string]Member
-	Services    map[string]Service
+	MembersURL     string
+	ServicesURL    string
+	Members        map[string]Member
+	Services       map[string]Service
+	mu             sync.RWMutex
+	updateHandlers []func()
 )
 
-func fetchAndValidateJSON(url string, target interface{}) error {
+func fetchURL(url string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.New("failed to fetch data")
+		return nil, errors.New("failed to fetch data")
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func fetchAndValidateJSON(url string, target interface{}) ([]byte, error) {
+	body, err := fetchURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := VerifySource(url, body); err != nil {
+		return nil, err
 	}
 
 	if err := json.Unmarshal(body, target); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func loadCachedJSON(name string, target interface{}) error {
+	body, snapshot, err := LoadSnapshot(name)
+	if err != nil {
 		return err
 	}
 
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("failed to unmarshal %s snapshot: %w", name, err)
+	}
+
+	log.Printf("Using cached %s snapshot from %s (%s)", name, snapshot.Timestamp.Format(time.RFC3339), snapshot.URL)
 	return nil
 }
 
+// loadCachedConfiguration loads the cached snapshot of one configuration file
+// into target, leaving it unchanged when there is none.
+func loadCachedConfiguration(name string, target interface{}) {
+	if err := loadCachedJSON(name, target); err != nil {
+		log.Printf("Error loading cached %s configuration: %v", name, err)
+	}
+}
+
+func logValidationReport(report ValidationReport) {
+	for _, issue := range report.Errors {
+		log.Printf("Configuration error: %s %s %s: %s", issue.Kind, issue.Name, issue.Field, issue.Message)
+	}
+	for _, issue := range report.Warnings {
+		log.Printf("Configuration warning: %s %s %s: %s", issue.Kind, issue.Name, issue.Field, issue.Message)
+	}
+}
+
 func updateConfigurations(done chan bool) {
 	log.Println("Fetching and updating configurations...")
 
+	mu.RLock()
+	currentMembers, currentServices := Members, Services
+	mu.RUnlock()
+
+	// A file that cannot be fetched keeps its current version, or its cached
+	// snapshot before one was loaded. The other file is still updated.
 	var newMembers map[string]Member
-	if err := fetchAndValidateJSON(MembersURL, &newMembers); err != nil {
+	membersBody, err := fetchAndValidateJSON(MembersURL, &newMembers)
+	if err != nil {
 		log.Printf("Error fetching members configuration: %v", err)
-	} else {
-		Members = newMembers
-		log.Println("Updated members configuration.")
+		newMembers = currentMembers
+		if newMembers == nil {
+			loadCachedConfiguration("members", &newMembers)
+		}
 	}
 
 	var newServices map[string]Service
-	if err := fetchAndValidateJSON(ServicesURL, &newServices); err != nil {
+	servicesBody, err := fetchAndValidateJSON(ServicesURL, &newServices)
+	if err != nil {
 		log.Printf("Error fetching services configuration: %v", err)
+		newServices = currentServices
+		if newServices == nil {
+			loadCachedConfiguration("services", &newServices)
+		}
+	}
+
+	// Before the first configuration is applied, freshly fetched files that
+	// fail validation are replaced by their cached snapshots.
+	report := Validate(newMembers, newServices)
+	if report.HasErrors() && currentMembers == nil && currentServices == nil && (membersBody != nil || servicesBody != nil) {
+		log.Printf("Configuration failed validation with %d errors, falling back to cached snapshots", len(report.Errors))
+		logValidationReport(report)
+		if membersBody != nil {
+			newMembers = nil
+			loadCachedConfiguration("members", &newMembers)
+		}
+		if servicesBody != nil {
+			newServices = nil
+			loadCachedConfiguration("services", &newServices)
+		}
+		membersBody, servicesBody = nil, nil
+		report = Validate(newMembers, newServices)
+	}
+	logValidationReport(report)
+
+	changed := false
+	if report.HasErrors() {
+		log.Printf("Configuration failed validation with %d errors, keeping previous configuration", len(report.Errors))
 	} else {
-		Services = newServices
-		log.Println("Updated services configuration.")
+		report.Applied = true
+
+		mu.Lock()
+		if !reflect.DeepEqual(Members, newMembers) || !reflect.DeepEqual(Services, newServices) {
+			Members = newMembers
+			Services = newServices
+			changed = true
+		}
+		mu.Unlock()
+
+		if membersBody != nil {
+			if err := SaveSnapshot("members", MembersURL, membersBody); err != nil {
+				log.Printf("Error saving members snapshot: %v", err)
+			}
+		}
+		if servicesBody != nil {
+			if err := SaveSnapshot("services", ServicesURL, servicesBody); err != nil {
+				log.Printf("Error saving services snapshot: %v", err)
+			}
+		}
+		log.Println("Updated members and services configuration.")
 	}
+	setValidationReport(report)
 
 	if done != nil {
 		done <- true
 		close(done)
-		done = nil
+		return
+	}
+
+	if changed {
+		log.Println("Configuration changed, notifying update handlers...")
+		mu.RLock()
+		handlers := append([]func(){}, updateHandlers...)
+		mu.RUnlock()
+		for _, handler := range handlers {
+			handler()
+		}
+	}
+}
+
+func startConfigUpdater(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		updateConfigurations(nil)
 	}
+}
 
+// OnUpdate registers a handler that is called after a periodic refresh
+// changed the members or services configuration.
+func OnUpdate(handler func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	updateHandlers = append(updateHandlers, handler)
 }
 
 func ExtractData() (map[string]map[string]Endpoint, map[string]MemberService, map[string]map[string]ServiceEndpoint) {
@@ -71,72 +207,204 @@ func ExtractData() (map[string]map[string]Endpoint, map[string]MemberService, ma
 	memberServices := make(map[string]MemberService)
 	serviceEndpoints := make(map[string]map[string]ServiceEndpoint)
 
+	mu.RLock()
+	defer mu.RUnlock()
+
 	for memberName, member := range Members {
 		if member.Service.Active != 1 {
 			continue
 		}
 
-		memberService := memberServices[memberName]
-		memberService.IPv4Addresses = appendUniqueString(memberService.IPv4Addresses, member.Service.ServiceIPv4)
-		memberService.IPv6Addresses = appendUniqueString(memberService.IPv6Addresses, member.Service.ServiceIPv6)
-
-		for _, services := range member.ServiceAssignments {
-			for _, service := range services {
-				if serviceConfig, exists := Services[service]; exists {
-					if serviceConfig.Configuration.Active == 1 && member.Membership.MemberLevel >= serviceConfig.Configuration.LevelRequired {
-						memberService.Services = appendUniqueString(memberService.Services, service)
-
-						for _, providerData := range serviceConfig.Providers {
-							for _, url := range providerData.RpcUrls {
-								dnsName := extractDNSName(url)
-								if dnsName != "" {
-									if endpoints[dnsName] == nil {
-										endpoints[dnsName] = make(map[string]Endpoint)
-									}
-									originalURL := OriginalURL{
-										URL:         url,
-										NetworkName: serviceConfig.Configuration.NetworkName,
-									}
-									endpoint := Endpoint{
-										MemberName:      memberName,
-										ExpectedNetwork: serviceConfig.Configuration.NetworkName,
-										IPv4:            member.Service.ServiceIPv4,
-										IPv6:            member.Service.ServiceIPv6,
-										Latitude:        member.Location.Latitude,
-										Longitude:       member.Location.Longitude,
-										OriginalURLs:    []OriginalURL{originalURL},
-									}
-									if existing, exists := endpoints[dnsName][memberName]; exists {
-										endpoint.OriginalURLs = append(existing.OriginalURLs, originalURL)
-									}
-									endpoints[dnsName][memberName] = endpoint
+		sites := member.GetSites()
+		for i, site := range sites {
+			siteKey := SiteKey(memberName, site.Name)
 
-									if serviceEndpoints[service] == nil {
-										serviceEndpoints[service] = make(map[string]ServiceEndpoint)
+			memberService := memberServices[siteKey]
+			memberService.MemberName = memberName
+			memberService.SiteName = site.Name
+			memberService.IPv4Addresses = appendUniqueString(memberService.IPv4Addresses, site.ServiceIPv4)
+			memberService.IPv6Addresses = appendUniqueString(memberService.IPv6Addresses, site.ServiceIPv6)
+
+			for _, services := range member.ServiceAssignments {
+				for _, service := range services {
+					if serviceConfig, exists := Services[service]; exists {
+						if serviceConfig.Configuration.Active == 1 && member.Membership.MemberLevel >= serviceConfig.Configuration.LevelRequired {
+							memberService.Services = appendUniqueString(memberService.Services, service)
+
+							rpcProviders := serviceConfig.Providers
+							if IsBootnodeService(serviceConfig.Configuration.ServiceType) {
+								rpcProviders = nil
+							}
+							for _, providerData := range rpcProviders {
+								for _, url := range providerData.RpcUrls {
+									dnsName := extractDNSName(url)
+									if dnsName != "" {
+										if endpoints[dnsName] == nil {
+											endpoints[dnsName] = make(map[string]Endpoint)
+										}
+										originalURL := OriginalURL{
+											URL:         url,
+											NetworkName: serviceConfig.Configuration.NetworkName,
+										}
+										endpoint := Endpoint{
+											MemberName:      memberName,
+											SiteName:        site.Name,
+											ExpectedNetwork: serviceConfig.Configuration.NetworkName,
+											IPv4:            site.ServiceIPv4,
+											IPv6:            site.ServiceIPv6,
+											Latitude:        site.Latitude,
+											Longitude:       site.Longitude,
+											OriginalURLs:    []OriginalURL{originalURL},
+										}
+										if existing, exists := endpoints[dnsName][siteKey]; exists {
+											endpoint.OriginalURLs = append(existing.OriginalURLs, originalURL)
+										}
+										endpoints[dnsName][siteKey] = endpoint
+
+										if serviceEndpoints[service] == nil {
+											serviceEndpoints[service] = make(map[string]ServiceEndpoint)
+										}
+										serviceEndpoint := serviceEndpoints[service][siteKey]
+										serviceEndpoint.ExpectedNetwork = serviceConfig.Configuration.NetworkName
+										serviceEndpoint.ServiceType = serviceConfig.Configuration.ServiceType
+										serviceEndpoint.ChainID = serviceConfig.Configuration.ChainID
+										serviceEndpoint.GenesisHash = serviceConfig.Configuration.GenesisHash
+										serviceEndpoint.SpecName = serviceConfig.Configuration.SpecName
+										serviceEndpoint.ArchiveDepth = serviceConfig.Configuration.ArchiveDepth
+										serviceEndpoint.URLs = append(serviceEndpoint.URLs, originalURL)
+										serviceEndpoint.ServiceIPv4s = appendUniqueString(serviceEndpoint.ServiceIPv4s, site.ServiceIPv4)
+										serviceEndpoint.ServiceIPv6s = appendUniqueString(serviceEndpoint.ServiceIPv6s, site.ServiceIPv6)
+										serviceEndpoint.Domains = appendUniqueString(serviceEndpoint.Domains, dnsName)
+										serviceEndpoints[service][siteKey] = serviceEndpoint
 									}
-									serviceEndpoint := serviceEndpoints[service][memberName]
-									serviceEndpoint.ExpectedNetwork = serviceConfig.Configuration.NetworkName
-									serviceEndpoint.URLs = append(serviceEndpoint.URLs, originalURL)
-									serviceEndpoint.ServiceIPv4s = appendUniqueString(serviceEndpoint.ServiceIPv4s, member.Service.ServiceIPv4)
-									serviceEndpoint.ServiceIPv6s = appendUniqueString(serviceEndpoint.ServiceIPv6s, member.Service.ServiceIPv6)
-									serviceEndpoint.Domains = appendUniqueString(serviceEndpoint.Domains, dnsName)
-									serviceEndpoints[service][memberName] = serviceEndpoint
 								}
 							}
+
+							// Bootnodes are listed under the provider named after the
+							// member, or after the site, and dialed by their own
+							// address, so each is checked from one site only.
+							bootNodes := siteBootNodes(sites, i, serviceConfig.Providers[memberName].BootNodes)
+							if siteKey != memberName {
+								bootNodes = append(bootNodes, serviceConfig.Providers[siteKey].BootNodes...)
+							}
+							if len(bootNodes) > 0 {
+								if serviceEndpoints[service] == nil {
+									serviceEndpoints[service] = make(map[string]ServiceEndpoint)
+								}
+								serviceEndpoint := serviceEndpoints[service][siteKey]
+								serviceEndpoint.ExpectedNetwork = serviceConfig.Configuration.NetworkName
+								serviceEndpoint.ServiceType = serviceConfig.Configuration.ServiceType
+								for _, bootNode := range bootNodes {
+									serviceEndpoint.BootNodes = appendUniqueString(serviceEndpoint.BootNodes, bootNode)
+								}
+								serviceEndpoints[service][siteKey] = serviceEndpoint
+							}
 						}
 					}
 				}
 			}
+			memberServices[siteKey] = memberService
 		}
-		memberServices[memberName] = memberService
 	}
 
 	return endpoints, memberServices, serviceEndpoints
 }
 
-func extractDNSName(url string) string {
-	if strings.HasPrefix(url, "wss://") || strings.HasPrefix(url, "https://") {
-		return strings.Split(strings.TrimPrefix(strings.TrimPrefix(url, "wss://"), "https://"), "/")[0]
+// siteBootNodes returns the member bootnodes checked from sites[index]: those
+// dialing one of its addresses, and on the first site those dialing no site
+// address, e.g. DNS names.
+func siteBootNodes(sites []Site, index int, bootNodes []string) []string {
+	owned := []string{}
+	for _, bootNode := range bootNodes {
+		owner := 0
+		if ip := net.ParseIP(bootNodeHost(bootNode)); ip != nil {
+			for j, site := range sites {
+				if ip.Equal(net.ParseIP(site.ServiceIPv4)) || ip.Equal(net.ParseIP(site.ServiceIPv6)) {
+					owner = j
+					break
+				}
+			}
+		}
+		if owner == index {
+			owned = append(owned, bootNode)
+		}
+	}
+	return owned
+}
+
+// bootNodeHost returns the address or DNS name a bootnode multiaddr dials.
+func bootNodeHost(multiaddr string) string {
+	parts := strings.Split(strings.Trim(multiaddr, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		switch parts[i] {
+		case "ip4", "ip6", "dns", "dns4", "dns6":
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// GetSites returns the sites a member serves from, falling back to a single
+// unnamed site described by Service and Location.
+func (m Member) GetSites() []Site {
+	if len(m.Sites) > 0 {
+		return m.Sites
+	}
+	return []Site{{
+		ServiceIPv4: m.Service.ServiceIPv4,
+		ServiceIPv6: m.Service.ServiceIPv6,
+		Region:      m.Location.Region,
+		Latitude:    m.Location.Latitude,
+		Longitude:   m.Location.Longitude,
+	}}
+}
+
+// SiteKey identifies a member site in DNS and monitor state. Single-site
+// members keep their plain member name.
+func SiteKey(memberName, siteName string) string {
+	if siteName == "" {
+		return memberName
+	}
+	return memberName + "@" + siteName
+}
+
+// ServiceTypeBootnode marks services that only list member bootnodes. Their
+// RPC URLs are ignored, so they are never served through DNS.
+const ServiceTypeBootnode = "bootnode"
+
+// IsBootnodeService reports whether a Configuration.ServiceType is the
+// bootnode service type.
+func IsBootnodeService(serviceType string) bool {
+	return strings.EqualFold(serviceType, ServiceTypeBootnode)
+}
+
+const (
+	FamilyIPv4      = "ipv4"
+	FamilyIPv6      = "ipv6"
+	IPv6CheckSuffix = "/" + FamilyIPv6
+)
+
+// FamilyCheckName returns the name results of a check are tracked under for
+// an address family. IPv4 results keep the plain check name.
+func FamilyCheckName(checkName, family string) string {
+	if family == FamilyIPv6 {
+		return checkName + IPv6CheckSuffix
+	}
+	return checkName
+}
+
+// IsIPv6Check reports whether a result key belongs to an IPv6 check.
+func IsIPv6Check(checkName string) bool {
+	return strings.HasSuffix(checkName, IPv6CheckSuffix)
+}
+
+func extractDNSName(rawURL string) string {
+	if strings.HasPrefix(rawURL, "wss://") || strings.HasPrefix(rawURL, "https://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(u.Hostname())
 	}
 	return ""
 }
@@ -153,8 +421,21 @@ func appendUniqueString(slice []string, item string) []string {
 	return append(slice, item)
 }
 
-func Init(done chan bool, membersURL, servicesURL string) {
-	MembersURL = membersURL
-	ServicesURL = servicesURL
-	go updateConfigurations(done)
+func Init(done chan bool, config *Config) {
+	MembersURL = config.MembersConfigUrl
+	ServicesURL = config.ServicesConfigUrl
+	InitSignatures(config.Signatures)
+	if config.CacheDir != "" {
+		CacheDir = config.CacheDir
+	}
+
+	interval := time.Duration(config.ConfigUpdateInterval) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+
+	go func() {
+		updateConfigurations(done)
+		startConfigUpdater(interval)
+	}()
 }
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signed sources carry a detached signature file next to the payload
+// (payload URL + Suffix). Each non-comment line of that file is either a raw
+// base64 ed25519 signature or a minisign signature line, so a file produced by
+// `minisign -S` works as well as several concatenated signatures.
+
+type trustedKey struct {
+	keyID     []byte
+	publicKey ed25519.PublicKey
+}
+
+var (
+	signatureConfig      *Signatures
+	trustedKeys          []trustedKey
+	signatureConfigMutex sync.RWMutex
+)
+
+func parseTrustedKey(key string) (trustedKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return trustedKey{}, fmt.Errorf("invalid base64: %w", err)
+	}
+
+	switch {
+	case len(decoded) == ed25519.PublicKeySize:
+		return trustedKey{publicKey: ed25519.PublicKey(decoded)}, nil
+	case len(decoded) == 2+8+ed25519.PublicKeySize && string(decoded[:2]) == "Ed":
+		// minisign public key: algorithm, key id, public key
+		return trustedKey{keyID: decoded[2:10], publicKey: ed25519.PublicKey(decoded[10:])}, nil
+	default:
+		return trustedKey{}, fmt.Errorf("unsupported key length %d", len(decoded))
+	}
+}
+
+// InitSignatures configures the trusted keys used by VerifySource.
+func InitSignatures(signatures *Signatures) {
+	keys := []trustedKey{}
+	if signatures != nil {
+		for _, key := range signatures.TrustedKeys {
+			parsed, err := parseTrustedKey(key)
+			if err != nil {
+				log.Printf("Ignoring trusted key '%s': %v", key, err)
+				continue
+			}
+			keys = append(keys, parsed)
+		}
+	}
+
+	signatureConfigMutex.Lock()
+	defer signatureConfigMutex.Unlock()
+	signatureConfig = signatures
+	trustedKeys = keys
+}
+
+// VerifySource fetches the detached signature for url and checks that body
+// was signed by at least Threshold distinct trusted keys. It is a no-op when
+// signature verification is disabled.
+func VerifySource(url string, body []byte) error {
+	signatureConfigMutex.RLock()
+	signatures, keys := signatureConfig, trustedKeys
+	signatureConfigMutex.RUnlock()
+
+	if signatures == nil || signatures.Enabled != 1 {
+		return nil
+	}
+
+	suffix := signatures.Suffix
+	if suffix == "" {
+		suffix = ".sig"
+	}
+
+	signatureFile, err := fetchURL(url + suffix)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature for %s: %w", url, err)
+	}
+
+	threshold := signatures.Threshold
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	valid := countValidSignatures(body, signatureFile, keys)
+	if valid < threshold {
+		return fmt.Errorf("signature verification failed for %s: %d of %d required trusted signatures", url, valid, threshold)
+	}
+
+	return nil
+}
+
+func countValidSignatures(body, signatureFile []byte, keys []trustedKey) int {
+	var prehashed []byte
+
+	// Signers are told apart by their public key, which a raw key and a
+	// minisign key can share.
+	verified := make(map[string]bool)
+	for _, line := range strings.Split(string(signatureFile), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") || strings.HasPrefix(line, "trusted comment:") {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range keys {
+			signer := string(key.publicKey)
+			if verified[signer] {
+				continue
+			}
+
+			switch {
+			case len(decoded) == ed25519.SignatureSize:
+				if ed25519.Verify(key.publicKey, body, decoded) {
+					verified[signer] = true
+				}
+			case len(decoded) == 2+8+ed25519.SignatureSize:
+				// minisign signature: "Ed" signs the payload, "ED" signs its BLAKE2b-512 hash
+				if key.keyID != nil && !bytes.Equal(key.keyID, decoded[2:10]) {
+					continue
+				}
+				message := body
+				if string(decoded[:2]) == "ED" {
+					if prehashed == nil {
+						sum := blake2b.Sum512(body)
+						prehashed = sum[:]
+					}
+					message = prehashed
+				} else if string(decoded[:2]) != "Ed" {
+					continue
+				}
+				if ed25519.Verify(key.publicKey, message, decoded[10:]) {
+					verified[signer] = true
+				}
+			}
+		}
+	}
+
+	return len(verified)
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	SnapshotSourceRemote = "remote"
+	SnapshotSourceCache  = "cache"
+)
+
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	URL       string    `json:"url"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var (
+	CacheDir       = "cache"
+	snapshots      = make(map[string]Snapshot)
+	snapshotsMutex sync.Mutex
+)
+
+func snapshotPaths(name string) (string, string) {
+	return filepath.Join(CacheDir, name+".json"), filepath.Join(CacheDir, name+".meta.json")
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SaveSnapshot persists a validated payload so it can be used when the remote
+// source is unreachable, and marks it as the snapshot currently in effect.
+func SaveSnapshot(name, url string, body []byte) error {
+	snapshot := Snapshot{
+		Name:      name,
+		Source:    SnapshotSourceRemote,
+		URL:       url,
+		Timestamp: time.Now(),
+	}
+
+	snapshotsMutex.Lock()
+	snapshots[name] = snapshot
+	snapshotsMutex.Unlock()
+
+	if err := os.MkdirAll(CacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	dataPath, metaPath := snapshotPaths(name)
+	if err := writeFileAtomic(dataPath, body); err != nil {
+		return fmt.Errorf("failed to write %s snapshot: %w", name, err)
+	}
+
+	meta, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s snapshot metadata: %w", name, err)
+	}
+	if err := writeFileAtomic(metaPath, meta); err != nil {
+		return fmt.Errorf("failed to write %s snapshot metadata: %w", name, err)
+	}
+
+	return nil
+}
+
+// LoadSnapshot returns the last payload stored by SaveSnapshot and marks it
+// as the snapshot currently in effect.
+func LoadSnapshot(name string) ([]byte, Snapshot, error) {
+	dataPath, metaPath := snapshotPaths(name)
+
+	body, err := os.ReadFile(dataPath)
+	if err != nil {
+		return nil, Snapshot{}, fmt.Errorf("failed to read %s snapshot: %w", name, err)
+	}
+
+	var snapshot Snapshot
+	meta, err := os.ReadFile(metaPath)
+	if err == nil {
+		err = json.Unmarshal(meta, &snapshot)
+	}
+	if err != nil {
+		snapshot = Snapshot{Name: name}
+		if info, statErr := os.Stat(dataPath); statErr == nil {
+			snapshot.Timestamp = info.ModTime()
+		}
+	}
+	snapshot.Source = SnapshotSourceCache
+
+	snapshotsMutex.Lock()
+	snapshots[name] = snapshot
+	snapshotsMutex.Unlock()
+
+	return body, snapshot, nil
+}
+
+// GetSnapshots returns the snapshot currently in effect for every source.
+func GetSnapshots() map[string]Snapshot {
+	snapshotsMutex.Lock()
+	defer snapshotsMutex.Unlock()
+
+	result := make(map[string]Snapshot, len(snapshots))
+	for name, snapshot := range snapshots {
+		result[name] = snapshot
+	}
+	return result
+}
@@ -1,23 +1,38 @@
 package config
 
+import "time"
+
 type CheckConfig struct {
 	Enabled       int                    `json:"Enabled"`
 	CheckType     string                 `json:"CheckType"`
 	Timeout       int                    `json:"Timeout"`
 	CheckInterval int                    `json:"CheckInterval"`
 	ExtraOptions  map[string]interface{} `json:"ExtraOptions"`
+	// Consecutive failures before a check reports failure, and consecutive
+	// successes before it reports recovery. Both default to 1.
+	FailureThreshold  int `json:"FailureThreshold"`
+	RecoveryThreshold int `json:"RecoveryThreshold"`
 }
 
 type Config struct {
-	ServerName         string                 `json:"ServerName"`
-	GeoliteDBPath      string                 `json:"GeoliteDBPath"`
-	StaticDNSConfigUrl string                 `json:"StaticDNSConfigUrl"`
-	MembersConfigUrl   string                 `json:"MembersConfigUrl"`
-	ServicesConfigUrl  string                 `json:"ServicesConfigUrl"`
-	MinimumOfflineTime int                    `json:"MinimumOfflineTime"`
-	AuthKey            map[string]string      `json:"AuthKey"`
-	Matrix             *Matrix                `json:"Matrix"`
-	Checks             map[string]CheckConfig `json:"Checks"`
+	ServerName            string                 `json:"ServerName"`
+	GeoliteDBPath         string                 `json:"GeoliteDBPath"`
+	ListenAddress         string                 `json:"ListenAddress"`
+	StaticDNSConfigUrl    string                 `json:"StaticDNSConfigUrl"`
+	MembersConfigUrl      string                 `json:"MembersConfigUrl"`
+	ServicesConfigUrl     string                 `json:"ServicesConfigUrl"`
+	ConfigUpdateInterval  int                    `json:"ConfigUpdateInterval"`
+	CacheDir              string                 `json:"CacheDir"`
+	MinimumOfflineTime    int                    `json:"MinimumOfflineTime"`
+	ResultsResyncInterval int                    `json:"ResultsResyncInterval"`
+	AuthKey               map[string]string      `json:"AuthKey"`
+	Matrix                *Matrix                `json:"Matrix"`
+	Signatures            *Signatures            `json:"Signatures"`
+	History               *History               `json:"History"`
+	Consensus             *Consensus             `json:"Consensus"`
+	Agents                []RemoteAgent          `json:"Agents"`
+	Agent                 *Agent                 `json:"Agent"`
+	Checks                map[string]CheckConfig `json:"Checks"`
 }
 
 type Matrix struct {
@@ -28,28 +43,91 @@ type Matrix struct {
 	RoomID        string `json:"RoomID"`
 }
 
-type SiteCheckResult struct {
-	CheckName  string                 `json:"checkname"`
-	Success    bool                   `json:"success"`
-	CheckError string                 `json:"checkerror,omitempty"`
-	CheckData  map[string]interface{} `json:"checkdata,omitempty"`
+type Signatures struct {
+	Enabled     int      `json:"Enabled"`
+	TrustedKeys []string `json:"TrustedKeys"`
+	Threshold   int      `json:"Threshold"`
+	Suffix      string   `json:"Suffix"`
+}
+
+type History struct {
+	Enabled         int    `json:"Enabled"`
+	Path            string `json:"Path"`
+	RetentionDays   int    `json:"RetentionDays"`
+	CompactInterval int    `json:"CompactInterval"`
+	MaxGap          int    `json:"MaxGap"`
+}
+
+// Consensus lets servers exchange check results with their peers and only
+// fail a check when Quorum servers agree.
+type Consensus struct {
+	Enabled  int             `json:"Enabled"`
+	Key      string          `json:"Key"`
+	Quorum   int             `json:"Quorum"`
+	Interval int             `json:"Interval"`
+	Timeout  int             `json:"Timeout"`
+	Peers    []ConsensusPeer `json:"Peers"`
+}
+
+type ConsensusPeer struct {
+	Name string `json:"Name"`
+	URL  string `json:"URL"`
 }
 
-type EndpointCheckResult struct {
-	CheckName  string                 `json:"checkname"`
-	Success    bool                   `json:"success"`
-	CheckError string                 `json:"checkerror,omitempty"`
-	CheckData  map[string]interface{} `json:"checkdata,omitempty"`
+// RemoteAgent is a probe agent allowed to push results to this server, which
+// are attributed to its Location.
+type RemoteAgent struct {
+	Name     string `json:"Name"`
+	Key      string `json:"Key"`
+	Location string `json:"Location"`
 }
 
-type SiteResults struct {
-	ResultType string                                `json:"resulttype"`
-	Members    map[string]map[string]SiteCheckResult `json:"members"`
+// Agent configures the agent run mode, which pushes the results of its checks
+// to the ingestion URL of each server.
+type Agent struct {
+	Name     string   `json:"Name"`
+	Key      string   `json:"Key"`
+	Servers  []string `json:"Servers"`
+	Interval int      `json:"Interval"`
+	Timeout  int      `json:"Timeout"`
 }
 
-type EndpointResults struct {
-	ResultType string                                               `json:"resulttype"`
-	Endpoint   map[string]map[string]map[string]EndpointCheckResult `json:"endpoint"`
+const (
+	ResultTypeSite     = "site"
+	ResultTypeEndpoint = "endpoint"
+)
+
+// CheckResult is the outcome of one check run, for a member site or for one
+// of its endpoints when ResultType is ResultTypeEndpoint.
+type CheckResult struct {
+	CheckName   string      `json:"checkname"`
+	MemberName  string      `json:"membername"`
+	ResultType  string      `json:"resulttype"`
+	EndpointURL string      `json:"endpointurl,omitempty"`
+	Success     bool        `json:"success"`
+	RawSuccess  bool        `json:"rawsuccess"`
+	Streak      int         `json:"streak"`
+	Error       string      `json:"error,omitempty"`
+	Data        interface{} `json:"data,omitempty"` // check specific payload, e.g. ibpmonitor.PingData
+	Timestamp   time.Time   `json:"timestamp"`
+	Votes       []Vote      `json:"votes,omitempty"`
+}
+
+// Vote is the result one server or probe agent got for a check.
+type Vote struct {
+	Server    string    `json:"server"`
+	Location  string    `json:"location,omitempty"`
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ResultStats counts the result batches the monitor sent to powerdns and the
+// batches it had to requeue because powerdns did not keep up.
+type ResultStats struct {
+	EmittedBatches uint64 `json:"emitted_batches"`
+	EmittedResults uint64 `json:"emitted_results"`
+	DroppedBatches uint64 `json:"dropped_batches"`
 }
 
 type Member struct {
@@ -75,6 +153,18 @@ type Member struct {
 		Latitude  float64 `json:"Latitude"`
 		Longitude float64 `json:"Longitude"`
 	} `json:"Location"`
+	Sites []Site `json:"Sites"`
+}
+
+// Site is one location a member serves from. Members without Sites are
+// treated as a single unnamed site built from Service and Location.
+type Site struct {
+	Name        string  `json:"Name"`
+	ServiceIPv4 string  `json:"ServiceIPv4"`
+	ServiceIPv6 string  `json:"ServiceIPv6"`
+	Region      string  `json:"Region"`
+	Latitude    float64 `json:"Latitude"`
+	Longitude   float64 `json:"Longitude"`
 }
 
 type Service struct {
@@ -83,14 +173,20 @@ type Service struct {
 		Active        int    `json:"Active"`
 		LevelRequired int    `json:"LevelRequired"`
 		NetworkName   string `json:"NetworkName"`
+		GenesisHash   string `json:"GenesisHash"`
+		SpecName      string `json:"SpecName"`
+		ArchiveDepth  int    `json:"ArchiveDepth"`
+		ChainID       uint64 `json:"ChainID"`
 	} `json:"Configuration"`
 	Providers map[string]struct {
-		RpcUrls []string `json:"RpcUrls"`
+		RpcUrls   []string `json:"RpcUrls"`
+		BootNodes []string `json:"BootNodes"`
 	} `json:"Providers"`
 }
 
 type Endpoint struct {
 	MemberName      string
+	SiteName        string
 	IPv4            string
 	IPv6            string
 	ExpectedNetwork string
@@ -105,6 +201,8 @@ type OriginalURL struct {
 }
 
 type MemberService struct {
+	MemberName    string
+	SiteName      string
 	IPv4Addresses []string
 	IPv6Addresses []string
 	Services      []string
@@ -112,6 +210,12 @@ type MemberService struct {
 
 type ServiceEndpoint struct {
 	ExpectedNetwork string
+	ServiceType     string
+	ChainID         uint64
+	GenesisHash     string
+	SpecName        string
+	ArchiveDepth    int
+	BootNodes       []string
 	URLs            []OriginalURL
 	ServiceIPv4s    []string
 	ServiceIPv6s    []string
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+type ValidationReport struct {
+	CheckedAt time.Time         `json:"checked_at"`
+	Applied   bool              `json:"applied"`
+	Errors    []ValidationIssue `json:"errors"`
+	Warnings  []ValidationIssue `json:"warnings"`
+}
+
+var (
+	validationReport      ValidationReport
+	validationReportMutex sync.Mutex
+
+	serviceTypes      = map[string]bool{"": true}
+	serviceTypesMutex sync.Mutex
+)
+
+// RegisterServiceType marks a Configuration.ServiceType as supported by the
+// monitor. Service types are compared case-insensitively.
+func RegisterServiceType(serviceType string) {
+	serviceTypesMutex.Lock()
+	defer serviceTypesMutex.Unlock()
+	serviceTypes[strings.ToLower(serviceType)] = true
+}
+
+func isKnownServiceType(serviceType string) bool {
+	serviceTypesMutex.Lock()
+	defer serviceTypesMutex.Unlock()
+	return serviceTypes[strings.ToLower(serviceType)]
+}
+
+func (r *ValidationReport) addIssue(severity, kind, name, field, format string, args ...interface{}) {
+	issue := ValidationIssue{
+		Severity: severity,
+		Kind:     kind,
+		Name:     name,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if severity == SeverityError {
+		r.Errors = append(r.Errors, issue)
+	} else {
+		r.Warnings = append(r.Warnings, issue)
+	}
+}
+
+func (r ValidationReport) HasErrors() bool {
+	return len(r.Errors) > 0
+}
+
+// Validate checks members and services for data that would silently break
+// routing. Errors mean the configuration must not be applied, warnings are
+// informational.
+func Validate(members map[string]Member, services map[string]Service) ValidationReport {
+	report := ValidationReport{
+		CheckedAt: time.Now(),
+		Errors:    []ValidationIssue{},
+		Warnings:  []ValidationIssue{},
+	}
+
+	if len(members) == 0 {
+		report.addIssue(SeverityError, "members", "", "", "no members defined")
+	}
+	if len(services) == 0 {
+		report.addIssue(SeverityError, "services", "", "", "no services defined")
+	}
+
+	for _, memberName := range sortedKeys(members) {
+		validateMember(&report, memberName, members[memberName], services)
+	}
+
+	urlOwners := make(map[string]string)
+	for _, serviceName := range sortedKeys(services) {
+		validateService(&report, serviceName, services[serviceName], urlOwners)
+	}
+
+	return report
+}
+
+func validateMember(report *ValidationReport, memberName string, member Member, services map[string]Service) {
+	severity := SeverityError
+	if member.Service.Active != 1 {
+		// Inactive members are not routed to, so problems are only reported.
+		severity = SeverityWarning
+	}
+
+	siteNames := make(map[string]bool)
+	for i, site := range member.GetSites() {
+		ipv4Field, ipv6Field, locationField := "Service.ServiceIPv4", "Service.ServiceIPv6", "Location"
+		if len(member.Sites) > 0 {
+			prefix := fmt.Sprintf("Sites[%d]", i)
+			ipv4Field, ipv6Field, locationField = prefix+".ServiceIPv4", prefix+".ServiceIPv6", prefix
+
+			if site.Name == "" {
+				report.addIssue(severity, "member", memberName, prefix+".Name", "missing site name")
+			} else if siteNames[site.Name] {
+				report.addIssue(severity, "member", memberName, prefix+".Name", "duplicate site name '%s'", site.Name)
+			}
+			siteNames[site.Name] = true
+		}
+
+		if site.ServiceIPv4 == "" {
+			report.addIssue(severity, "member", memberName, ipv4Field, "missing IPv4 address")
+		} else if ip := net.ParseIP(site.ServiceIPv4); ip == nil || ip.To4() == nil {
+			report.addIssue(severity, "member", memberName, ipv4Field, "invalid IPv4 address '%s'", site.ServiceIPv4)
+		}
+
+		if site.ServiceIPv6 != "" {
+			if ip := net.ParseIP(site.ServiceIPv6); ip == nil || ip.To4() != nil {
+				report.addIssue(severity, "member", memberName, ipv6Field, "invalid IPv6 address '%s'", site.ServiceIPv6)
+			}
+		}
+
+		if site.Latitude == 0 && site.Longitude == 0 {
+			report.addIssue(severity, "member", memberName, locationField, "missing location coordinates")
+		}
+		if site.Latitude < -90 || site.Latitude > 90 {
+			report.addIssue(severity, "member", memberName, locationField+".Latitude", "latitude %v out of range", site.Latitude)
+		}
+		if site.Longitude < -180 || site.Longitude > 180 {
+			report.addIssue(severity, "member", memberName, locationField+".Longitude", "longitude %v out of range", site.Longitude)
+		}
+		if site.Region == "" {
+			report.addIssue(SeverityWarning, "member", memberName, locationField+".Region", "missing region")
+		}
+	}
+
+	for _, assignment := range sortedKeys(member.ServiceAssignments) {
+		for _, serviceName := range member.ServiceAssignments[assignment] {
+			service, exists := services[serviceName]
+			if !exists {
+				report.addIssue(severity, "member", memberName, "ServiceAssignments."+assignment, "unknown service '%s'", serviceName)
+				continue
+			}
+			if member.Membership.MemberLevel < service.Configuration.LevelRequired {
+				report.addIssue(SeverityWarning, "member", memberName, "ServiceAssignments."+assignment,
+					"member level %d is below level %d required by service '%s'", member.Membership.MemberLevel, service.Configuration.LevelRequired, serviceName)
+			}
+		}
+	}
+}
+
+func validateService(report *ValidationReport, serviceName string, service Service, urlOwners map[string]string) {
+	severity := SeverityError
+	if service.Configuration.Active != 1 {
+		severity = SeverityWarning
+	}
+
+	if service.Configuration.NetworkName == "" {
+		report.addIssue(severity, "service", serviceName, "Configuration.NetworkName", "missing network name")
+	}
+	if serviceType := service.Configuration.ServiceType; !isKnownServiceType(serviceType) {
+		report.addIssue(severity, "service", serviceName, "Configuration.ServiceType", "unknown service type '%s'", serviceType)
+	}
+	if genesisHash := service.Configuration.GenesisHash; genesisHash != "" && !isBlockHash(genesisHash) {
+		report.addIssue(severity, "service", serviceName, "Configuration.GenesisHash", "invalid genesis hash '%s'", genesisHash)
+	}
+	if service.Configuration.ArchiveDepth < 0 {
+		report.addIssue(severity, "service", serviceName, "Configuration.ArchiveDepth", "negative archive depth %d", service.Configuration.ArchiveDepth)
+	}
+
+	bootnodeService := IsBootnodeService(service.Configuration.ServiceType)
+	bootNodes := 0
+	for _, providerName := range sortedKeys(service.Providers) {
+		seen := make(map[string]bool)
+		for _, rpcUrl := range service.Providers[providerName].RpcUrls {
+			field := "Providers." + providerName + ".RpcUrls"
+			if bootnodeService {
+				report.addIssue(SeverityWarning, "service", serviceName, field, "RPC URL '%s' of a bootnode service will be ignored", rpcUrl)
+				continue
+			}
+
+			u, err := url.Parse(rpcUrl)
+			if err != nil || u.Hostname() == "" {
+				report.addIssue(severity, "service", serviceName, field, "invalid RPC URL '%s'", rpcUrl)
+				continue
+			}
+			if u.Scheme != "wss" && u.Scheme != "https" {
+				report.addIssue(SeverityWarning, "service", serviceName, field, "RPC URL '%s' is neither wss:// nor https:// and will be ignored", rpcUrl)
+			}
+
+			if seen[rpcUrl] {
+				report.addIssue(severity, "service", serviceName, field, "duplicate RPC URL '%s'", rpcUrl)
+				continue
+			}
+			seen[rpcUrl] = true
+
+			normalized := strings.ToLower(strings.TrimSuffix(rpcUrl, "/"))
+			if owner, exists := urlOwners[normalized]; exists && owner != serviceName {
+				report.addIssue(severity, "service", serviceName, field, "RPC URL '%s' is also used by service '%s'", rpcUrl, owner)
+				continue
+			}
+			urlOwners[normalized] = serviceName
+		}
+
+		for _, bootNode := range service.Providers[providerName].BootNodes {
+			bootNodes++
+			if !strings.HasPrefix(bootNode, "/") || !strings.Contains(bootNode, "/tcp/") || !strings.Contains(bootNode, "/p2p/") {
+				report.addIssue(severity, "service", serviceName, "Providers."+providerName+".BootNodes", "invalid bootnode multiaddr '%s'", bootNode)
+			}
+		}
+	}
+	if bootnodeService && bootNodes == 0 {
+		report.addIssue(severity, "service", serviceName, "Providers", "bootnode service lists no bootnodes")
+	}
+}
+
+// isBlockHash reports whether s is a 0x prefixed 32 byte hex hash.
+func isBlockHash(s string) bool {
+	if len(s) != 66 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func setValidationReport(report ValidationReport) {
+	validationReportMutex.Lock()
+	defer validationReportMutex.Unlock()
+	validationReport = report
+}
+
+// GetValidationReport returns the report of the most recent configuration update.
+func GetValidationReport() ValidationReport {
+	validationReportMutex.Lock()
+	defer validationReportMutex.Unlock()
+	return validationReport
+}
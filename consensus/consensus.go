@@ -0,0 +1,433 @@
+package consensus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"ibp-geodns/config"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval       = 10
+	defaultTimeout        = 5
+	defaultResyncInterval = 60 * time.Second
+	maxClockSkew          = 5 * time.Minute
+	maxResponseSize       = 64 << 20
+
+	TimestampHeader = "X-GeoDNS-Timestamp"
+	SignatureHeader = "X-GeoDNS-Signature"
+	AgentHeader     = "X-GeoDNS-Agent"
+)
+
+// PeerResults is what a server returns to its peers: the results of its own
+// checks, before consensus.
+type PeerResults struct {
+	Server  string               `json:"server"`
+	Results []config.CheckResult `json:"results"`
+}
+
+// PeerStatus describes the last exchange with a peer or agent. Votes of a peer
+// that has not answered for three intervals are ignored, as are those of an
+// agent that missed three pushes.
+type PeerStatus struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	URL        string    `json:"url,omitempty"`
+	Location   string    `json:"location,omitempty"`
+	LastUpdate time.Time `json:"last_update"`
+	LastError  string    `json:"last_error,omitempty"`
+	Results    int       `json:"results"`
+	Fresh      bool      `json:"fresh"`
+}
+
+type peer struct {
+	name       string
+	url        string
+	location   string
+	key        string
+	results    map[string]config.CheckResult
+	lastUpdate time.Time
+	lastError  string
+	staleAfter time.Duration
+
+	// lastTimestamp is the signed time of the last push of an agent, which
+	// must increase so pushes cannot be replayed.
+	lastTimestamp int64
+}
+
+type localResult struct {
+	result config.CheckResult
+	seen   time.Time
+}
+
+var (
+	started        bool
+	settings       config.Consensus
+	serverName     string
+	interval       time.Duration
+	resyncInterval time.Duration
+	peers          []*peer
+	agents         []*peer
+	local          = make(map[string]localResult)
+	mu             sync.RWMutex
+
+	// sent is the signature of the last result forwarded per check, so votes
+	// changing on a peer are forwarded without waiting for the monitor.
+	sent = make(map[string]string)
+)
+
+// Start exchanges check results with the configured peers, accepts results of
+// the configured agents and returns the channel powerdns reads the agreed
+// results from. Without peers or agents it returns results unchanged.
+func Start(cfg *config.Config, results chan []config.CheckResult) chan []config.CheckResult {
+	// Quorum and Interval also apply to agents when peers are disabled.
+	if cfg.Consensus != nil {
+		settings = *cfg.Consensus
+		if settings.Enabled != 1 {
+			settings.Key = ""
+		} else if settings.Key == "" {
+			log.Printf("Consensus with peers is disabled: no Key configured")
+		}
+	}
+	if settings.Key == "" && len(cfg.Agents) == 0 {
+		return results
+	}
+
+	started = true
+	serverName = cfg.ServerName
+	interval = seconds(settings.Interval, defaultInterval)
+	resyncInterval = defaultResyncInterval
+	if cfg.ResultsResyncInterval > 0 {
+		resyncInterval = time.Duration(cfg.ResultsResyncInterval) * time.Second
+	}
+	if settings.Key != "" {
+		for _, peerConfig := range settings.Peers {
+			peers = append(peers, &peer{name: peerConfig.Name, url: peerConfig.URL, staleAfter: 3 * interval})
+		}
+	}
+	for _, agentConfig := range cfg.Agents {
+		if agentConfig.Key == "" {
+			log.Printf("Ignoring agent %s without a Key", agentConfig.Name)
+			continue
+		}
+		agents = append(agents, &peer{name: agentConfig.Name, location: agentConfig.Location, key: agentConfig.Key})
+	}
+
+	log.Printf("Sharing check results with %d peers and %d agents, %d must agree to fail a check", len(peers), len(agents), quorum())
+
+	client := &http.Client{Timeout: seconds(settings.Timeout, defaultTimeout)}
+	for _, p := range peers {
+		go poll(p, client)
+	}
+
+	agreed := make(chan []config.CheckResult)
+	go run(results, agreed)
+	return agreed
+}
+
+// Enabled reports whether results are exchanged with peers or agents.
+func Enabled() bool {
+	return started
+}
+
+func seconds(value, fallback int) time.Duration {
+	if value <= 0 {
+		value = fallback
+	}
+	return time.Duration(value) * time.Second
+}
+
+// quorum is the number of servers and agents that must report a failure to
+// fail a check, by default a majority of all of them.
+func quorum() int {
+	if settings.Quorum > 0 {
+		return settings.Quorum
+	}
+	return (len(peers)+len(agents)+1)/2 + 1
+}
+
+func resultKey(result config.CheckResult) string {
+	return result.MemberName + " " + result.EndpointURL + "::" + result.CheckName
+}
+
+// run forwards every local result with the votes of the peers and agents,
+// and every second forwards the checks whose votes changed since.
+func run(results, agreed chan []config.CheckResult) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch, ok := <-results:
+			if !ok {
+				close(agreed)
+				return
+			}
+			now := time.Now()
+			mu.Lock()
+			for _, result := range batch {
+				local[resultKey(result)] = localResult{result: result, seen: now}
+			}
+			mu.Unlock()
+
+			forward := make([]config.CheckResult, 0, len(batch))
+			for _, result := range batch {
+				result = agree(result)
+				sent[resultKey(result)] = signature(result)
+				forward = append(forward, result)
+			}
+			agreed <- forward
+		case <-ticker.C:
+			if changed := changedResults(); len(changed) > 0 {
+				agreed <- changed
+			}
+		}
+	}
+}
+
+// changedResults returns the local results whose agreed state or votes
+// changed, and drops results the monitor no longer sends.
+func changedResults() []config.CheckResult {
+	mu.Lock()
+	results := make([]config.CheckResult, 0, len(local))
+	for key, entry := range local {
+		if time.Since(entry.seen) > 3*resyncInterval {
+			delete(local, key)
+			delete(sent, key)
+			continue
+		}
+		results = append(results, entry.result)
+	}
+	mu.Unlock()
+
+	changed := []config.CheckResult{}
+	for _, result := range results {
+		result = agree(result)
+		key := resultKey(result)
+		if sent[key] != signature(result) {
+			sent[key] = signature(result)
+			changed = append(changed, result)
+		}
+	}
+	return changed
+}
+
+// agree returns result with the votes of this server and of every peer and
+// agent with a fresh result for the same check. The check fails when quorum
+// voters report a failure, or when fewer voted and all of them did.
+func agree(result config.CheckResult) config.CheckResult {
+	key := resultKey(result)
+	votes := []config.Vote{{Server: serverName, Success: result.Success, Error: result.Error, Timestamp: result.Timestamp}}
+
+	mu.RLock()
+	for _, p := range append(append([]*peer{}, peers...), agents...) {
+		if !p.fresh() {
+			continue
+		}
+		if peerResult, exists := p.results[key]; exists {
+			votes = append(votes, config.Vote{Server: p.name, Location: p.location, Success: peerResult.Success, Error: peerResult.Error, Timestamp: peerResult.Timestamp})
+		}
+	}
+	mu.RUnlock()
+
+	failed := []string{}
+	for _, vote := range votes {
+		if !vote.Success {
+			failed = append(failed, fmt.Sprintf("%s: %s", vote.Server, vote.Error))
+		}
+	}
+	required := quorum()
+	if required > len(votes) {
+		required = len(votes)
+	}
+
+	result.Votes = votes
+	if len(failed) >= required {
+		result.Success = false
+		result.Error = fmt.Sprintf("failed on %d of %d voters (%s)", len(failed), len(votes), strings.Join(failed, "; "))
+	} else {
+		result.Success = true
+		result.Error = ""
+	}
+	return result
+}
+
+// signature identifies the agreed state and the votes of a result.
+func signature(result config.CheckResult) string {
+	var sb strings.Builder
+	sb.WriteString(strconv.FormatBool(result.Success))
+	for _, vote := range result.Votes {
+		sb.WriteString(" " + vote.Server + "=" + strconv.FormatBool(vote.Success))
+	}
+	return sb.String()
+}
+
+func (p *peer) fresh() bool {
+	return !p.lastUpdate.IsZero() && time.Since(p.lastUpdate) <= p.staleAfter
+}
+
+func poll(p *peer, client *http.Client) {
+	for {
+		results, err := fetch(client, p.url)
+
+		mu.Lock()
+		if err != nil {
+			if p.lastError == "" {
+				log.Printf("Failed to fetch results from peer %s: %v", p.name, err)
+			}
+			p.lastError = err.Error()
+		} else {
+			if p.lastError != "" || p.lastUpdate.IsZero() {
+				log.Printf("Receiving %d results from peer %s", len(results), p.name)
+			}
+			p.results = results
+			p.lastUpdate = time.Now()
+			p.lastError = ""
+		}
+		mu.Unlock()
+
+		time.Sleep(interval)
+	}
+}
+
+// fetch requests the results of a peer. The request is signed with the shared
+// key and the current time, the response together with that time, so neither
+// can be forged or replayed without the key.
+func fetch(client *http.Client, peerURL string) (map[string]config.CheckResult, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodGet, peerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(settings.Key, timestamp))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if !verify(settings.Key, timestamp+"\n"+string(body), resp.Header.Get(SignatureHeader)) {
+		return nil, fmt.Errorf("invalid response signature")
+	}
+
+	var peerResults PeerResults
+	if err := json.Unmarshal(body, &peerResults); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	results := make(map[string]config.CheckResult, len(peerResults.Results))
+	for _, result := range peerResults.Results {
+		results[resultKey(result)] = result
+	}
+	return results, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of message with key.
+func Sign(key, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(key, message, signature string) bool {
+	return hmac.Equal([]byte(Sign(key, message)), []byte(signature))
+}
+
+// signedTimestamp parses a signed unix timestamp that is within the allowed
+// clock skew.
+func signedTimestamp(value string) (int64, bool) {
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	return timestamp, skew <= maxClockSkew && skew >= -maxClockSkew
+}
+
+// Handler serves the results of this server to peers signing their request
+// with the shared key.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if settings.Key == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		timestamp := r.Header.Get(TimestampHeader)
+		if _, valid := signedTimestamp(timestamp); !valid || !verify(settings.Key, timestamp, r.Header.Get(SignatureHeader)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := json.Marshal(PeerResults{Server: serverName, Results: localResults()})
+		if err != nil {
+			http.Error(w, "Error encoding response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(SignatureHeader, Sign(settings.Key, timestamp+"\n"+string(body)))
+		w.Write(body)
+	})
+}
+
+// localResults returns the results of this server without their check data.
+func localResults() []config.CheckResult {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	results := make([]config.CheckResult, 0, len(local))
+	for _, entry := range local {
+		result := entry.result
+		result.Data = nil
+		result.Votes = nil
+		results = append(results, result)
+	}
+	return results
+}
+
+// Peers returns the state of the exchange with every peer and agent.
+func Peers() []PeerStatus {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	statuses := make([]PeerStatus, 0, len(peers)+len(agents))
+	for _, p := range peers {
+		statuses = append(statuses, p.status("peer"))
+	}
+	for _, a := range agents {
+		statuses = append(statuses, a.status("agent"))
+	}
+	return statuses
+}
+
+func (p *peer) status(peerType string) PeerStatus {
+	return PeerStatus{
+		Name:       p.name,
+		Type:       peerType,
+		URL:        p.url,
+		Location:   p.location,
+		LastUpdate: p.lastUpdate,
+		LastError:  p.lastError,
+		Results:    len(p.results),
+		Fresh:      p.fresh(),
+	}
+}
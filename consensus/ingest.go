@@ -0,0 +1,95 @@
+package consensus
+
+import (
+	"encoding/json"
+	"ibp-geodns/config"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxPushAge bounds how far the signed time of an agent push may be from the
+// clock of the server, so that a captured push cannot be replayed later.
+const maxPushAge = 30 * time.Second
+
+// AgentResults is what a probe agent pushes to a server: the latest result of
+// every check it runs, and how often it pushes them.
+type AgentResults struct {
+	Agent    string               `json:"agent"`
+	Interval int                  `json:"interval"`
+	Results  []config.CheckResult `json:"results"`
+}
+
+func findAgent(name string) *peer {
+	for _, a := range agents {
+		if a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// IngestHandler accepts results pushed by the configured agents. A push is
+// signed with the key of the agent over its timestamp and body, and replaces
+// the results previously pushed by that agent. Pushes signed more than
+// maxPushAge away from the server time, or not after the last accepted push,
+// are rejected.
+func IngestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.Header.Get(AgentHeader)
+		agent := findAgent(name)
+		if agent == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxResponseSize))
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		timestamp, valid := signedTimestamp(r.Header.Get(TimestampHeader))
+		if !valid || !verify(agent.key, r.Header.Get(TimestampHeader)+"\n"+string(body), r.Header.Get(SignatureHeader)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if age := time.Since(time.Unix(timestamp, 0)); age > maxPushAge || age < -maxPushAge {
+			http.Error(w, "Push timestamp is too far from the server time", http.StatusUnauthorized)
+			return
+		}
+
+		var pushed AgentResults
+		if err := json.Unmarshal(body, &pushed); err != nil || pushed.Agent != name {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		results := make(map[string]config.CheckResult, len(pushed.Results))
+		for _, result := range pushed.Results {
+			results[resultKey(result)] = result
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if timestamp <= agent.lastTimestamp {
+			http.Error(w, "Push is not newer than the last one", http.StatusConflict)
+			return
+		}
+		if !agent.fresh() {
+			log.Printf("Receiving %d results from agent %s", len(results), name)
+		}
+		agent.results = results
+		agent.lastUpdate = time.Now()
+		agent.lastTimestamp = timestamp
+		agent.staleAfter = 3 * seconds(pushed.Interval, defaultInterval)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
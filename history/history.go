@@ -0,0 +1,488 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultRetentionDays   = 30
+	defaultCompactInterval = 3600
+	defaultLimit           = 1000
+	flushInterval          = 1 * time.Second
+)
+
+var (
+	resultsBucket     = []byte("results")
+	transitionsBucket = []byte("transitions")
+	overridesBucket   = []byte("overrides")
+	buckets           = [][]byte{resultsBucket, transitionsBucket, overridesBucket}
+)
+
+// Transition is a change of the state powerdns serves a member with. Domain is
+// empty for site checks, which apply to every domain of the member. Initial
+// marks the first state observed for a check after a start, with From equal
+// to To.
+type Transition struct {
+	Timestamp   time.Time `json:"timestamp"`
+	MemberName  string    `json:"membername"`
+	Domain      string    `json:"domain,omitempty"`
+	EndpointURL string    `json:"endpointurl,omitempty"`
+	CheckName   string    `json:"checkname"`
+	From        bool      `json:"from"`
+	To          bool      `json:"to"`
+	Initial     bool      `json:"initial,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Override records a member being taken out of (or put back into) all
+// rotations through the API, e.g. for maintenance.
+type Override struct {
+	Timestamp  time.Time `json:"timestamp"`
+	MemberName string    `json:"membername"`
+	Override   bool      `json:"override"`
+}
+
+// Query selects records of one member (a member name selects all of its sites)
+// and check within [From, To]. Empty fields match everything. Limit defaults
+// to 1000, a negative limit returns all records.
+type Query struct {
+	MemberName string
+	CheckName  string
+	Domain     string
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+type record struct {
+	bucket []byte
+	member string
+	time   time.Time
+	value  []byte
+}
+
+var (
+	db       *bolt.DB
+	dbMutex  sync.RWMutex
+	queue    []record
+	queueMu  sync.Mutex
+	sequence uint32
+)
+
+// Open opens the history database and starts writing queued records and
+// pruning old ones. It is a no-op when history is disabled.
+func Open(settings *config.History) error {
+	if settings == nil || settings.Enabled != 1 {
+		return nil
+	}
+
+	path := settings.Path
+	if path == "" {
+		path = filepath.Join(config.CacheDir, "history.db")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	store, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open history database %s: %w", path, err)
+	}
+	err = store.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		store.Close()
+		return fmt.Errorf("failed to initialize history database: %w", err)
+	}
+
+	dbMutex.Lock()
+	db = store
+	dbMutex.Unlock()
+
+	retention := settings.RetentionDays
+	if retention <= 0 {
+		retention = defaultRetentionDays
+	}
+	compactInterval := settings.CompactInterval
+	if compactInterval <= 0 {
+		compactInterval = defaultCompactInterval
+	}
+
+	log.Printf("Recording history in %s for %d days", path, retention)
+	go flushLoop()
+	go compactLoop(time.Duration(retention)*24*time.Hour, time.Duration(compactInterval)*time.Second)
+	return nil
+}
+
+// Enabled reports whether a history database is open.
+func Enabled() bool {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	return db != nil
+}
+
+// RecordResult queues a check result for writing.
+func RecordResult(result config.CheckResult) {
+	enqueue(resultsBucket, result.MemberName, result.Timestamp, result)
+}
+
+// RecordTransition queues a status transition for writing.
+func RecordTransition(transition Transition) {
+	enqueue(transitionsBucket, transition.MemberName, transition.Timestamp, transition)
+}
+
+// RecordOverride queues an override change for writing.
+func RecordOverride(override Override) {
+	enqueue(overridesBucket, override.MemberName, override.Timestamp, override)
+}
+
+func enqueue(bucket []byte, member string, timestamp time.Time, value interface{}) {
+	if !Enabled() {
+		return
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode history record: %v", err)
+		return
+	}
+
+	queueMu.Lock()
+	queue = append(queue, record{bucket: bucket, member: member, time: timestamp, value: encoded})
+	queueMu.Unlock()
+}
+
+// flushLoop writes queued records once a second in a single transaction, since
+// every transaction syncs the database file.
+func flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		queueMu.Lock()
+		records := queue
+		queue = nil
+		queueMu.Unlock()
+
+		if len(records) == 0 {
+			continue
+		}
+		if err := write(records); err != nil {
+			log.Printf("Failed to write %d history records: %v", len(records), err)
+		}
+	}
+}
+
+func write(records []record) error {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, rec := range records {
+			memberBucket, err := tx.Bucket(rec.bucket).CreateBucketIfNotExists([]byte(rec.member))
+			if err != nil {
+				return err
+			}
+			if err := memberBucket.Put(recordKey(rec.time), rec.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// recordKey orders records by time. The sequence number keeps records with
+// the same timestamp apart.
+func recordKey(timestamp time.Time) []byte {
+	sequence++
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, uint64(timestamp.UnixNano()))
+	binary.BigEndian.PutUint32(key[8:], sequence)
+	return key
+}
+
+func timeKey(timestamp time.Time) []byte {
+	key := make([]byte, 8)
+	if timestamp.After(time.Unix(0, 0)) {
+		binary.BigEndian.PutUint64(key, uint64(timestamp.UnixNano()))
+	}
+	return key
+}
+
+func compactLoop(retention, interval time.Duration) {
+	for {
+		if err := Compact(time.Now().Add(-retention)); err != nil {
+			log.Printf("Failed to compact history: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Compact deletes records older than cutoff and member buckets left empty.
+// Freed pages are reused by new records.
+func Compact(cutoff time.Time) error {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	if db == nil {
+		return nil
+	}
+
+	deleted := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			parent := tx.Bucket(name)
+			empty := [][]byte{}
+			err := parent.ForEach(func(member, _ []byte) error {
+				memberBucket := parent.Bucket(member)
+				if memberBucket == nil {
+					return nil
+				}
+				cursor := memberBucket.Cursor()
+				for key, _ := cursor.First(); key != nil && bytes.Compare(key[:8], timeKey(cutoff)) < 0; key, _ = cursor.First() {
+					if err := cursor.Delete(); err != nil {
+						return err
+					}
+					deleted++
+				}
+				if key, _ := cursor.First(); key == nil {
+					empty = append(empty, append([]byte{}, member...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, member := range empty {
+				if err := parent.DeleteBucket(member); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil && deleted > 0 {
+		log.Printf("Removed %d history records older than %s", deleted, cutoff.Format(time.RFC3339))
+	}
+	return err
+}
+
+// Results returns the recorded check results matching query, oldest first.
+func Results(query Query) ([]config.CheckResult, error) {
+	results := []config.CheckResult{}
+	err := scan(resultsBucket, query, func(value []byte) bool {
+		var result config.CheckResult
+		if err := json.Unmarshal(value, &result); err != nil {
+			return false
+		}
+		if query.CheckName != "" && !matchCheck(result.CheckName, query.CheckName) {
+			return false
+		}
+		if query.Domain != "" && result.ResultType == config.ResultTypeEndpoint && EndpointDomain(result.EndpointURL) != query.Domain {
+			return false
+		}
+		results = append(results, result)
+		return true
+	})
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+	if limit := queryLimit(query); len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, err
+}
+
+// Transitions returns the recorded status transitions matching query, oldest
+// first.
+func Transitions(query Query) ([]Transition, error) {
+	transitions := []Transition{}
+	err := scan(transitionsBucket, query, func(value []byte) bool {
+		var transition Transition
+		if err := json.Unmarshal(value, &transition); err != nil {
+			return false
+		}
+		if query.CheckName != "" && !matchCheck(transition.CheckName, query.CheckName) {
+			return false
+		}
+		if query.Domain != "" && transition.Domain != "" && transition.Domain != query.Domain {
+			return false
+		}
+		transitions = append(transitions, transition)
+		return true
+	})
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].Timestamp.Before(transitions[j].Timestamp)
+	})
+	if limit := queryLimit(query); len(transitions) > limit {
+		transitions = transitions[len(transitions)-limit:]
+	}
+	return transitions, err
+}
+
+// Gap is a period in which no results were recorded for a member.
+type Gap struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Gaps returns the periods overlapping from and to in which no result was
+// recorded for memberName, a site key, for longer than maxGap, e.g. while
+// the server or its history was down. Only record keys are read.
+func Gaps(memberName string, from, to time.Time, maxGap time.Duration) ([]Gap, error) {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	if db == nil {
+		return nil, fmt.Errorf("history is not enabled")
+	}
+
+	gaps := []Gap{}
+	err := db.View(func(tx *bolt.Tx) error {
+		last := from
+		memberBucket := tx.Bucket(resultsBucket).Bucket([]byte(memberName))
+		if memberBucket != nil {
+			// Start from the last record before the period.
+			cursor := memberBucket.Cursor()
+			var previous []byte
+			if key, _ := cursor.Seek(timeKey(from)); key == nil {
+				previous, _ = cursor.Last()
+			} else {
+				previous, _ = cursor.Prev()
+			}
+			if previous != nil {
+				last = keyTime(previous)
+			}
+			for key, _ := cursor.Seek(timeKey(from)); key != nil && bytes.Compare(key[:8], timeKey(to)) <= 0; key, _ = cursor.Next() {
+				timestamp := keyTime(key)
+				if timestamp.Sub(last) > maxGap {
+					gaps = append(gaps, Gap{From: last, To: timestamp})
+				}
+				last = timestamp
+			}
+		}
+		if to.Sub(last) > maxGap {
+			gaps = append(gaps, Gap{From: last, To: to})
+		}
+		return nil
+	})
+	return gaps, err
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+// EndpointDomain returns the domain of an endpoint result key.
+func EndpointDomain(key string) string {
+	host, _, _ := strings.Cut(key, "/")
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// Overrides returns the recorded override changes matching query, oldest
+// first.
+func Overrides(query Query) ([]Override, error) {
+	overrides := []Override{}
+	err := scan(overridesBucket, query, func(value []byte) bool {
+		var override Override
+		if err := json.Unmarshal(value, &override); err != nil {
+			return false
+		}
+		overrides = append(overrides, override)
+		return true
+	})
+	sort.SliceStable(overrides, func(i, j int) bool {
+		return overrides[i].Timestamp.Before(overrides[j].Timestamp)
+	})
+	if limit := queryLimit(query); len(overrides) > limit {
+		overrides = overrides[len(overrides)-limit:]
+	}
+	return overrides, err
+}
+
+// matchCheck matches a check name with or without its /ipv6 suffix.
+func matchCheck(checkName, wanted string) bool {
+	return checkName == wanted || strings.TrimSuffix(checkName, config.IPv6CheckSuffix) == wanted
+}
+
+func queryLimit(query Query) int {
+	if query.Limit < 0 {
+		return math.MaxInt
+	}
+	if query.Limit == 0 {
+		return defaultLimit
+	}
+	return query.Limit
+}
+
+// scan calls match for the records of every selected member within the query
+// time range, newest first, until limit records of that member matched.
+func scan(bucket []byte, query Query, match func(value []byte) bool) error {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	if db == nil {
+		return fmt.Errorf("history is not enabled")
+	}
+
+	limit := queryLimit(query)
+	to := query.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	fromKey, toKey := timeKey(query.From), timeKey(to)
+
+	return db.View(func(tx *bolt.Tx) error {
+		parent := tx.Bucket(bucket)
+		return parent.ForEach(func(member, _ []byte) error {
+			name := string(member)
+			if query.MemberName != "" && name != query.MemberName && !strings.HasPrefix(name, query.MemberName+"@") {
+				return nil
+			}
+			memberBucket := parent.Bucket(member)
+			if memberBucket == nil {
+				return nil
+			}
+
+			// Newest records first, so the limit keeps the most recent ones.
+			matched := 0
+			cursor := memberBucket.Cursor()
+			key, value := cursor.Seek(append(toKey, 0xff, 0xff, 0xff, 0xff))
+			if key == nil {
+				key, value = cursor.Last()
+			} else {
+				key, value = cursor.Prev()
+			}
+			for ; key != nil && bytes.Compare(key[:8], fromKey) >= 0 && matched < limit; key, value = cursor.Prev() {
+				if bytes.Compare(key[:8], toKey) > 0 {
+					continue
+				}
+				if match(value) {
+					matched++
+				}
+			}
+			return nil
+		})
+	})
+}
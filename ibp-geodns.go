@@ -2,7 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
+	"fmt"
+	"ibp-geodns/agent"
 	"ibp-geodns/config"
+	"ibp-geodns/consensus"
+	"ibp-geodns/history"
 	"ibp-geodns/ibpmonitor"
 	"ibp-geodns/powerdns"
 	"log"
@@ -27,63 +32,55 @@ func loadConfig(filename string) (*config.Config, error) {
 	return config, nil
 }
 
-func main() {
-	log.Println("Starting the application...")
-
-	configfile, err := loadConfig("config.json")
-	if err != nil {
-		log.Printf("Failed to load config: %v", err)
-	}
-
-	done := make(chan bool)
-	config.Init(done, configfile.MembersConfigUrl, configfile.ServicesConfigUrl)
-
-	log.Println("Waiting for initial configuration to be ready...")
-	<-done
-	log.Println("Initial configuration is ready")
-
-	log.Println("Extracting DNS and Endpoints...")
-	endpoints, memberServices, serviceEndpoints := config.ExtractData()
-	log.Println("Extraction complete")
-
+func buildPowerDNSConfigs(endpoints map[string]map[string]config.Endpoint) []powerdns.DNS {
 	var powerDNSConfigs []powerdns.DNS
 	for dns, members := range endpoints {
 		dnsConfig := powerdns.DNS{
 			Domain:  dns,
 			Members: make(map[string]powerdns.Member),
 		}
-		for memberName, endpoint := range members {
+		for siteKey, endpoint := range members {
 			member := powerdns.Member{
-				MemberName: memberName,
+				MemberName: endpoint.MemberName,
+				SiteName:   endpoint.SiteName,
 				IPv4:       endpoint.IPv4,
 				IPv6:       endpoint.IPv6,
 				Latitude:   endpoint.Latitude,
 				Longitude:  endpoint.Longitude,
 				Results:    make(map[string]powerdns.Result),
 			}
-			dnsConfig.Members[memberName] = member
+			dnsConfig.Members[siteKey] = member
 		}
 		powerDNSConfigs = append(powerDNSConfigs, dnsConfig)
 	}
-	log.Println("PowerDNS configuration populated")
+	return powerDNSConfigs
+}
 
+func buildMonitorMembers(memberServices map[string]config.MemberService, serviceEndpoints map[string]map[string]config.ServiceEndpoint) []ibpmonitor.Member {
 	var ibpMonitorConfigs []ibpmonitor.Member
-	for memberName, service := range memberServices {
+	for siteKey, service := range memberServices {
 		member := ibpmonitor.Member{
-			MemberName:  memberName,
+			MemberName:  service.MemberName,
+			SiteName:    service.SiteName,
 			IPv4Address: strings.Join(service.IPv4Addresses, ", "),
 			IPv6Address: strings.Join(service.IPv6Addresses, ", "),
 		}
 
 		for _, serviceName := range service.Services {
-			if serviceEndpoint, exists := serviceEndpoints[serviceName][memberName]; exists {
+			if serviceEndpoint, exists := serviceEndpoints[serviceName][siteKey]; exists {
 				endpoints := []string{}
 				for _, url := range serviceEndpoint.URLs {
 					endpoints = append(endpoints, url.URL)
 				}
 				service := ibpmonitor.Service{
-					ServiceName: serviceEndpoint.ExpectedNetwork,
-					Endpoints:   endpoints,
+					ServiceName:  serviceEndpoint.ExpectedNetwork,
+					ServiceType:  serviceEndpoint.ServiceType,
+					ChainID:      serviceEndpoint.ChainID,
+					GenesisHash:  serviceEndpoint.GenesisHash,
+					SpecName:     serviceEndpoint.SpecName,
+					ArchiveDepth: serviceEndpoint.ArchiveDepth,
+					BootNodes:    serviceEndpoint.BootNodes,
+					Endpoints:    endpoints,
 				}
 				member.Services = append(member.Services, service)
 			}
@@ -91,12 +88,140 @@ func main() {
 
 		ibpMonitorConfigs = append(ibpMonitorConfigs, member)
 	}
+	return ibpMonitorConfigs
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s <command> [options]
+
+Commands:
+  serve            Run the GeoDNS backend and health monitor (default)
+  validate         Validate members/services configuration files
+  validate-config  Validate the service configuration file
+  resolve          Simulate a lookup for a client IP
+  check            Run checks once for a member and print the results
+  agent            Run the checks as a remote probe pushing results to servers
+
+Run '%s <command> -h' for the options of a command.
+`, os.Args[0], os.Args[0])
+}
+
+func main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "validate":
+		validate(args)
+	case "validate-config":
+		validateConfig(args)
+	case "resolve":
+		resolve(args)
+	case "check":
+		check(args)
+	case "agent":
+		runAgent(args)
+	case "help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", envOrDefault("GEODNS_CONFIG", "config.json"), "configuration file (env GEODNS_CONFIG)")
+	listenAddress := flags.String("listen", os.Getenv("GEODNS_LISTEN"), "listen address, overrides ListenAddress (env GEODNS_LISTEN)")
+	flags.Parse(args)
+
+	log.Println("Starting the application...")
+
+	configfile, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if *listenAddress != "" {
+		configfile.ListenAddress = *listenAddress
+	}
+
+	done := make(chan bool)
+	config.Init(done, configfile)
+
+	log.Println("Waiting for initial configuration to be ready...")
+	<-done
+	log.Println("Initial configuration is ready")
+
+	log.Println("Extracting DNS and Endpoints...")
+	endpoints, memberServices, serviceEndpoints := config.ExtractData()
+	if len(endpoints) == 0 {
+		log.Fatalf("No DNS entries could be extracted, members and services are unavailable remotely and in the cache")
+	}
+	log.Println("Extraction complete")
+
+	powerDNSConfigs := buildPowerDNSConfigs(endpoints)
+	log.Println("PowerDNS configuration populated")
+
+	ibpMonitorConfigs := buildMonitorMembers(memberServices, serviceEndpoints)
 	log.Println("IBP Monitor configuration populated")
 
+	if err := history.Open(configfile.History); err != nil {
+		log.Printf("History is disabled: %v", err)
+	}
+
 	healthChecker := ibpmonitor.NewIbpMonitor(ibpMonitorConfigs, configfile)
-	resultsChannel := healthChecker.Start()
+	resultsChannel := consensus.Start(configfile, healthChecker.Start())
+	healthChecker.RegisterMetrics()
 
 	powerdns.Init(powerDNSConfigs, resultsChannel, configfile)
+	powerdns.SetResultStats(healthChecker.ResultStats)
+
+	config.OnUpdate(func() {
+		log.Println("Applying updated configuration...")
+		endpoints, memberServices, serviceEndpoints := config.ExtractData()
+		healthChecker.SyncMembers(buildMonitorMembers(memberServices, serviceEndpoints))
+		powerdns.UpdateConfigs(buildPowerDNSConfigs(endpoints))
+		log.Println("Updated configuration applied")
+	})
 
 	select {}
 }
+
+func runAgent(args []string) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	configPath := flags.String("config", envOrDefault("GEODNS_CONFIG", "config.json"), "configuration file (env GEODNS_CONFIG)")
+	flags.Parse(args)
+
+	log.Println("Starting the probe agent...")
+
+	configfile, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if configfile.Agent == nil || configfile.Agent.Name == "" || configfile.Agent.Key == "" || len(configfile.Agent.Servers) == 0 {
+		log.Fatalf("Agent mode needs an Agent section with a Name, a Key and Servers")
+	}
+
+	done := make(chan bool)
+	config.Init(done, configfile)
+	<-done
+
+	_, memberServices, serviceEndpoints := config.ExtractData()
+	if len(memberServices) == 0 {
+		log.Fatalf("No members could be extracted, members and services are unavailable remotely and in the cache")
+	}
+
+	healthChecker := ibpmonitor.NewIbpMonitor(buildMonitorMembers(memberServices, serviceEndpoints), configfile)
+	resultsChannel := healthChecker.Start()
+
+	config.OnUpdate(func() {
+		_, memberServices, serviceEndpoints := config.ExtractData()
+		healthChecker.SyncMembers(buildMonitorMembers(memberServices, serviceEndpoints))
+	})
+
+	agent.Run(configfile, resultsChannel)
+}
@@ -1,22 +1,53 @@
 package ibpmonitor
 
 import (
+	"fmt"
+	"ibp-geodns/config"
+	"sync"
 	"time"
 )
 
 var (
-	checks = make(map[string]Check)
+	checksMutex     sync.Mutex
+	checks          = make(map[string]Check)
+	endpointFilters = make(map[string]func(endpoint string) bool)
+	serviceFilters  = make(map[string]func(service Service) bool)
+	endpointKeys    = make(map[string]func(member Member) []string)
 )
 
 func RegisterCheck(name string, check Check) {
-	resultTypesMutex.Lock()
-	defer resultTypesMutex.Unlock()
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
 	checks[name] = check
 }
 
+// RegisterEndpointFilter limits the endpoints an endpoint check covers, so the
+// wrapper only reports timeouts and panics for endpoints the check would probe.
+func RegisterEndpointFilter(name string, filter func(endpoint string) bool) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	endpointFilters[name] = filter
+}
+
+// RegisterServiceFilter limits the services an endpoint check covers, for
+// checks that only apply to some service types.
+func RegisterServiceFilter(name string, filter func(service Service) bool) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	serviceFilters[name] = filter
+}
+
+// RegisterEndpointKeys registers the result keys of an endpoint check that
+// does not probe RPC endpoints, so timeouts and panics are reported for them.
+func RegisterEndpointKeys(name string, keys func(member Member) []string) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	endpointKeys[name] = keys
+}
+
 func GetCheck(name string) (Check, bool) {
-	resultTypesMutex.Lock()
-	defer resultTypesMutex.Unlock()
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
 	check, exists := checks[name]
 	return check, exists
 }
@@ -29,17 +60,77 @@ func (r *IbpMonitor) performCheck(checkName string) {
 		return
 	}
 
+	options := r.Config.Checks[checkName]
+	check, exists := LookupCheck(checkName, options)
+	if !exists {
+		return
+	}
+
 	for _, member := range r.Members {
-		if check, exists := checks[checkName]; exists {
-			go CheckWrapper(checkName, check, member, r.Config.Checks[checkName], r.ResultsCollectorChannel)
+		for _, target := range member.addressFamilies() {
+			go CheckWrapper(checkName, check, target, options, r.ResultsCollectorChannel)
 			time.Sleep(1 * time.Millisecond)
 		}
 	}
 }
 
+// RunCheck runs a single check once for one member and returns the raw results
+// it produced.
+func RunCheck(checkName string, member Member, options config.CheckConfig) ([]config.CheckResult, error) {
+	check, exists := LookupCheck(checkName, options)
+	if !exists {
+		return nil, fmt.Errorf("unknown check '%s'", checkName)
+	}
+
+	resultsCollectorChannel := make(chan config.CheckResult, 1024)
+	for _, target := range member.addressFamilies() {
+		CheckWrapper(checkName, check, target, options, resultsCollectorChannel)
+	}
+
+	results := []config.CheckResult{}
+	for {
+		select {
+		case result := <-resultsCollectorChannel:
+			results = append(results, result)
+		default:
+			return results, nil
+		}
+	}
+}
+
 func getIntOption(extraOptions map[string]interface{}, key string, defaultValue int) int {
 	if value, ok := extraOptions[key].(float64); ok {
 		return int(value)
 	}
 	return defaultValue
 }
+
+func getStringListOption(extraOptions map[string]interface{}, key string, defaultValue []string) []string {
+	values, ok := extraOptions[key].([]interface{})
+	if !ok {
+		return defaultValue
+	}
+	list := []string{}
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			list = append(list, str)
+		}
+	}
+	return list
+}
+
+func getBoolOption(extraOptions map[string]interface{}, key string, defaultValue bool) bool {
+	if value, ok := extraOptions[key].(bool); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func appendUnique(slice []string, item string) []string {
+	for _, elem := range slice {
+		if elem == item {
+			return slice
+		}
+	}
+	return append(slice, item)
+}
@@ -0,0 +1,268 @@
+package ibpmonitor
+
+import (
+	"context"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+type BlockLagData struct {
+	Best               uint64 `json:"best"`
+	Finalized          uint64 `json:"finalized"`
+	Samples            int    `json:"samples"`
+	ConsensusBest      uint64 `json:"consensus_best,omitempty"`
+	ConsensusFinalized uint64 `json:"consensus_finalized,omitempty"`
+	BestLag            uint64 `json:"best_lag"`
+	FinalizedLag       uint64 `json:"finalized_lag"`
+}
+
+// blockObservation is the chain head one member endpoint reported.
+type blockObservation struct {
+	Best      uint64
+	Finalized uint64
+	Observed  time.Time
+}
+
+// Every member endpoint records its heights here, per service and member site,
+// so the consensus of a service is built from the heights all members serving
+// it reported.
+var (
+	blockObservations      = make(map[string]map[string]map[string]blockObservation)
+	blockObservationsMutex sync.Mutex
+)
+
+func BlockLagCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+
+	var MaxConcurrentChecks = 20
+	delayBetweenChecks := 1 * time.Millisecond
+	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
+	maxBestLag := getIntOption(options.ExtraOptions, "MaxBestLag", 10)
+	maxFinalizedLag := getIntOption(options.ExtraOptions, "MaxFinalizedLag", 20)
+	maxSampleAge := getIntOption(options.ExtraOptions, "MaxSampleAge", 2*options.CheckInterval)
+	minSamples := getIntOption(options.ExtraOptions, "MinSamples", 3)
+	checkName := member.checkName("blocklag")
+
+	sem := semaphore.NewWeighted(int64(MaxConcurrentChecks))
+
+	var wg sync.WaitGroup
+
+	for _, service := range member.Services {
+		if !service.hasBlockHeights() {
+			continue
+		}
+
+		for _, endpoint := range service.Endpoints {
+			if err := sem.Acquire(context.Background(), 1); err != nil {
+				log.Printf("Failed to acquire semaphore: %v", err)
+				continue
+			}
+
+			time.Sleep(delayBetweenChecks)
+
+			wg.Add(1)
+			go func(service Service, endpoint string) {
+				defer sem.Release(1)
+				defer wg.Done()
+
+				target, err := parseEndpoint(endpoint)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to parse endpoint '%s': %v", endpoint, err)
+					sendResult(checkName, member.ID(), "invalid-hostname", config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				client, closeClient, err := newRPCClient(target, member.address(), time.Duration(connectTimeout)*time.Second)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to connect to endpoint (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+				defer closeClient()
+
+				getHeights := getBlockHeights
+				if service.isEthereum() {
+					getHeights = getEthBlockHeights
+				}
+				observation, err := getHeights(client)
+				if err != nil {
+					errMsg := fmt.Sprintf("Block lag check failed (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				// IPv4 and IPv6 report the same node, so they share one sample.
+				recordBlockObservation(service.ServiceName, member.ID(), target.Key, observation)
+				consensus, samples := blockConsensus(service.ServiceName, time.Duration(maxSampleAge)*time.Second)
+
+				data := BlockLagData{
+					Best:      observation.Best,
+					Finalized: observation.Finalized,
+					Samples:   samples,
+				}
+
+				if samples < minSamples {
+					// Not enough members reported yet to tell who is behind.
+					sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, true, "", data, resultsCollectorChannel)
+					return
+				}
+
+				bestLag := heightLag(consensus.Best, observation.Best)
+				finalizedLag := heightLag(consensus.Finalized, observation.Finalized)
+				data.ConsensusBest = consensus.Best
+				data.ConsensusFinalized = consensus.Finalized
+				data.BestLag = bestLag
+				data.FinalizedLag = finalizedLag
+
+				if bestLag > uint64(maxBestLag) || finalizedLag > uint64(maxFinalizedLag) {
+					errMsg := fmt.Sprintf("Block lag check failed (Member: %s URL: '%s' Error: best block %d is %d behind %d (max %d), finalized block %d is %d behind %d (max %d))",
+						member.ID(), endpoint, observation.Best, bestLag, consensus.Best, maxBestLag, observation.Finalized, finalizedLag, consensus.Finalized, maxFinalizedLag)
+					sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, data, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, true, "", data, resultsCollectorChannel)
+			}(service, endpoint)
+		}
+	}
+
+	wg.Wait()
+}
+
+// getBlockHeights reads the best and finalized block numbers of a node.
+func getBlockHeights(client rpcClient) (blockObservation, error) {
+	best, err := getHeaderNumber(client, []interface{}{})
+	if err != nil {
+		return blockObservation{}, err
+	}
+
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "chain_getFinalizedHead",
+		Params:  []interface{}{},
+		ID:      2,
+	})
+	if err != nil {
+		return blockObservation{}, err
+	}
+	finalizedHash, ok := response["result"].(string)
+	if !ok || finalizedHash == "" {
+		return blockObservation{}, fmt.Errorf("chain_getFinalizedHead result is invalid")
+	}
+
+	finalized, err := getHeaderNumber(client, []interface{}{finalizedHash})
+	if err != nil {
+		return blockObservation{}, err
+	}
+
+	return blockObservation{Best: best, Finalized: finalized, Observed: time.Now()}, nil
+}
+
+func getHeaderNumber(client rpcClient, params []interface{}) (uint64, error) {
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "chain_getHeader",
+		Params:  params,
+		ID:      1,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	header, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("chain_getHeader result is invalid")
+	}
+	number, ok := header["number"].(string)
+	if !ok {
+		return 0, fmt.Errorf("chain_getHeader block number is invalid")
+	}
+
+	height, err := strconv.ParseUint(strings.TrimPrefix(number, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("chain_getHeader block number '%s' is invalid: %v", number, err)
+	}
+	return height, nil
+}
+
+func recordBlockObservation(serviceName, memberID, endpointKey string, observation blockObservation) {
+	blockObservationsMutex.Lock()
+	defer blockObservationsMutex.Unlock()
+
+	if blockObservations[serviceName] == nil {
+		blockObservations[serviceName] = make(map[string]map[string]blockObservation)
+	}
+	if blockObservations[serviceName][memberID] == nil {
+		blockObservations[serviceName][memberID] = make(map[string]blockObservation)
+	}
+	blockObservations[serviceName][memberID][endpointKey] = observation
+}
+
+// blockConsensus returns the median best and finalized heights of the recent
+// observations of a service, and the number of members they came from. Each
+// member site counts once with the best heights of its endpoints, so members
+// with many endpoints do not outvote the others. The median keeps a single
+// node reporting bogus heights from failing everyone else.
+func blockConsensus(serviceName string, maxAge time.Duration) (blockObservation, int) {
+	blockObservationsMutex.Lock()
+	defer blockObservationsMutex.Unlock()
+
+	best := []uint64{}
+	finalized := []uint64{}
+	for memberID, endpoints := range blockObservations[serviceName] {
+		var memberBest, memberFinalized uint64
+		for endpointKey, observation := range endpoints {
+			if time.Since(observation.Observed) > maxAge {
+				delete(endpoints, endpointKey)
+				continue
+			}
+			memberBest = max(memberBest, observation.Best)
+			memberFinalized = max(memberFinalized, observation.Finalized)
+		}
+		if len(endpoints) == 0 {
+			delete(blockObservations[serviceName], memberID)
+			continue
+		}
+		best = append(best, memberBest)
+		finalized = append(finalized, memberFinalized)
+	}
+
+	if len(best) == 0 {
+		return blockObservation{}, 0
+	}
+	return blockObservation{Best: median(best), Finalized: median(finalized)}, len(best)
+}
+
+func median(values []uint64) uint64 {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values[len(values)/2]
+}
+
+func heightLag(consensus, height uint64) uint64 {
+	if height >= consensus {
+		return 0
+	}
+	return consensus - height
+}
+
+// hasBlockHeights reports whether the block lag check can read the heights
+// of a service.
+func (s Service) hasBlockHeights() bool {
+	return s.isSubstrate() || s.isEthereum()
+}
+
+func init() {
+	RegisterCheck("blocklag", BlockLagCheck)
+	RegisterServiceFilter("blocklag", Service.hasBlockHeights)
+}
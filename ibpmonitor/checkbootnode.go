@@ -0,0 +1,153 @@
+package ibpmonitor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+type BootnodeData struct {
+	Transport string `json:"transport"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+func BootnodeCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+
+	var MaxConcurrentChecks = 20
+	delayBetweenChecks := 1 * time.Millisecond
+	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
+	checkName := member.checkName("bootnode")
+
+	sem := semaphore.NewWeighted(int64(MaxConcurrentChecks))
+
+	var wg sync.WaitGroup
+
+	for _, multiaddr := range collectBootNodes(member) {
+		if err := sem.Acquire(context.Background(), 1); err != nil {
+			log.Printf("Failed to acquire semaphore: %v", err)
+			continue
+		}
+
+		time.Sleep(delayBetweenChecks)
+
+		wg.Add(1)
+		go func(multiaddr string) {
+			defer sem.Release(1)
+			defer wg.Done()
+
+			addr, err := parseMultiaddr(multiaddr)
+			if err != nil {
+				errMsg := fmt.Sprintf("Failed to parse bootnode '%s': %v", multiaddr, err)
+				sendResult(checkName, member.ID(), "invalid-hostname", config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+				log.Println(errMsg)
+				return
+			}
+
+			// Bootnodes are advertised by address, so each check only dials
+			// the multiaddrs of its family, and /dns/ names over its family.
+			family := bootnodeFamily(addr)
+			if family != "" && family != member.family {
+				return
+			}
+
+			start := time.Now()
+			peerID, err := handshakeBootnode(addr, member.family, time.Duration(connectTimeout)*time.Second)
+			if err != nil {
+				errMsg := fmt.Sprintf("Bootnode check failed (Member: %s Bootnode: '%s' Error: %v)", member.ID(), multiaddr, err)
+				sendResult(checkName, member.ID(), addr.Key(), config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+				log.Println(errMsg)
+				return
+			}
+
+			data := BootnodeData{
+				Transport: addr.Transport,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+
+			if peerID != addr.PeerID {
+				errMsg := fmt.Sprintf("Bootnode check failed (Member: %s Bootnode: '%s' Error: peer ID is %s instead of %s)", member.ID(), multiaddr, peerID, addr.PeerID)
+				sendResultWithData(checkName, member.ID(), addr.Key(), config.ResultTypeEndpoint, false, errMsg, data, resultsCollectorChannel)
+				log.Println(errMsg)
+				return
+			}
+
+			sendResultWithData(checkName, member.ID(), addr.Key(), config.ResultTypeEndpoint, true, "", data, resultsCollectorChannel)
+		}(multiaddr)
+	}
+
+	wg.Wait()
+}
+
+// handshakeBootnode negotiates Noise with a bootnode and returns its peer ID.
+func handshakeBootnode(addr bootnodeAddr, family string, timeout time.Duration) (string, error) {
+	stream, err := dialBootnode(addr, family, timeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %v", err)
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	if err := negotiateNoise(stream, reader); err != nil {
+		return "", err
+	}
+	return noiseHandshakePeerID(stream, reader)
+}
+
+// bootnodeFamily returns the address family a multiaddr is bound to, or an
+// empty string for /dns/ names, which resolve to either.
+func bootnodeFamily(addr bootnodeAddr) string {
+	switch {
+	case strings.HasPrefix(addr.Multiaddr, "/ip6/"), strings.HasPrefix(addr.Multiaddr, "/dns6/"):
+		return config.FamilyIPv6
+	case strings.HasPrefix(addr.Multiaddr, "/ip4/"), strings.HasPrefix(addr.Multiaddr, "/dns4/"):
+		return config.FamilyIPv4
+	}
+	return ""
+}
+
+// collectBootNodes collects the unique bootnode multiaddrs of a member.
+func collectBootNodes(member Member) []string {
+	bootNodes := []string{}
+	for _, service := range member.Services {
+		for _, multiaddr := range service.BootNodes {
+			bootNodes = appendUnique(bootNodes, multiaddr)
+		}
+	}
+	return bootNodes
+}
+
+// bootnodeKeys returns the result keys of a member's bootnodes.
+func bootnodeKeys(member Member) []string {
+	keys := []string{}
+	for _, multiaddr := range collectBootNodes(member) {
+		if addr, err := parseMultiaddr(multiaddr); err == nil {
+			keys = append(keys, addr.Key())
+		}
+	}
+	return keys
+}
+
+// isBootnode reports whether a service only lists bootnodes. The RPC checks
+// skip such services, the bootnode check covers the bootnodes of every
+// service.
+func (s Service) isBootnode() bool {
+	return config.IsBootnodeService(s.ServiceType)
+}
+
+// servesRPC reports whether the RPC checks probe the endpoints of a service.
+func (s Service) servesRPC() bool {
+	return !s.isBootnode()
+}
+
+func init() {
+	RegisterCheck("bootnode", BootnodeCheck)
+	RegisterEndpointKeys("bootnode", bootnodeKeys)
+	config.RegisterServiceType(config.ServiceTypeBootnode)
+}
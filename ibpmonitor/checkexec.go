@@ -0,0 +1,204 @@
+package ibpmonitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ExecOutput is the JSON document an exec check command prints on stdout.
+type ExecOutput struct {
+	Success bool        `json:"success"`
+	Error   string      `json:"error,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// execTimeoutMargin leaves the check time to report a killed command before
+// the wrapper reports the whole check as timed out.
+const execTimeoutMargin = 500 * time.Millisecond
+
+// LookupCheck returns the check run for a configured check: a registered check
+// of that name, or an exec check when its ExtraOptions set a Command.
+func LookupCheck(name string, options config.CheckConfig) (Check, bool) {
+	if check, exists := GetCheck(name); exists {
+		return check, true
+	}
+	if len(execCommand(options)) > 0 {
+		return execCheck(name), true
+	}
+	return nil, false
+}
+
+// execCommand returns the configured command, either a list of the program and
+// its arguments or a single program path.
+func execCommand(options config.CheckConfig) []string {
+	if command, ok := options.ExtraOptions["Command"].(string); ok && command != "" {
+		return []string{command}
+	}
+	return getStringListOption(options.ExtraOptions, "Command", nil)
+}
+
+// execCheck runs the configured command once per member, or once per endpoint
+// for endpoint checks, and reports the result it prints.
+func execCheck(name string) Check {
+	return func(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+		checkName := member.checkName(name)
+		command := execCommand(options)
+
+		timeout := time.Duration(options.Timeout)*time.Second - execTimeoutMargin
+		if timeout <= 0 {
+			timeout = time.Duration(options.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if options.CheckType != config.ResultTypeEndpoint {
+			output, err := runExecCommand(ctx, command, execEnv(name, member, Service{}, ""))
+			if err != nil {
+				errMsg := fmt.Sprintf("%s check failed for member %s: %v", checkName, member.ID(), err)
+				sendResultWithData(checkName, member.ID(), "", config.ResultTypeSite, false, errMsg, output.Data, resultsCollectorChannel)
+				log.Println(errMsg)
+				return
+			}
+			sendResultWithData(checkName, member.ID(), "", config.ResultTypeSite, true, "", output.Data, resultsCollectorChannel)
+			return
+		}
+
+		sem := semaphore.NewWeighted(int64(getIntOption(options.ExtraOptions, "MaxConcurrent", 10)))
+		var wg sync.WaitGroup
+
+		seen := make(map[string]bool)
+		for _, service := range member.Services {
+			for _, endpoint := range service.Endpoints {
+				// Endpoints differing only in scheme share a result key.
+				if seen[endpointKey(endpoint)] {
+					continue
+				}
+				seen[endpointKey(endpoint)] = true
+
+				if err := sem.Acquire(ctx, 1); err != nil {
+					errMsg := fmt.Sprintf("%s check for member %s timed out before running", checkName, member.ID())
+					sendResult(checkName, member.ID(), endpointKey(endpoint), config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					continue
+				}
+
+				wg.Add(1)
+				go func(service Service, endpoint string) {
+					defer sem.Release(1)
+					defer wg.Done()
+
+					key := endpointKey(endpoint)
+					output, err := runExecCommand(ctx, command, execEnv(name, member, service, endpoint))
+					if err != nil {
+						errMsg := fmt.Sprintf("%s check failed (Member: %s URL: '%s' Error: %v)", checkName, member.ID(), endpoint, err)
+						sendResultWithData(checkName, member.ID(), key, config.ResultTypeEndpoint, false, errMsg, output.Data, resultsCollectorChannel)
+						log.Println(errMsg)
+						return
+					}
+					sendResultWithData(checkName, member.ID(), key, config.ResultTypeEndpoint, true, "", output.Data, resultsCollectorChannel)
+				}(service, endpoint)
+			}
+		}
+
+		wg.Wait()
+	}
+}
+
+// execEnv describes the check target to the command. Arguments can refer to
+// the same values with ${GEODNS_...} placeholders.
+func execEnv(name string, member Member, service Service, endpoint string) []string {
+	env := []string{
+		"GEODNS_CHECK=" + name,
+		"GEODNS_MEMBER=" + member.ID(),
+		"GEODNS_MEMBER_NAME=" + member.MemberName,
+		"GEODNS_SITE=" + member.SiteName,
+		"GEODNS_IP=" + member.address(),
+		"GEODNS_IPV4=" + member.IPv4Address,
+		"GEODNS_IPV6=" + member.IPv6Address,
+		"GEODNS_FAMILY=" + member.family,
+	}
+	if endpoint != "" {
+		env = append(env,
+			"GEODNS_ENDPOINT="+endpoint,
+			"GEODNS_ENDPOINT_KEY="+endpointKey(endpoint),
+			"GEODNS_SERVICE="+service.ServiceName,
+			"GEODNS_SERVICE_TYPE="+service.ServiceType,
+		)
+	}
+	return env
+}
+
+// runExecCommand runs command with env added to the environment of the
+// service. The check fails when the command exits non-zero, is killed at the
+// deadline or does not print an ExecOutput reporting success.
+func runExecCommand(ctx context.Context, command []string, env []string) (ExecOutput, error) {
+	values := make(map[string]string, len(env))
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		values[key] = value
+	}
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = os.Expand(arg, func(key string) string {
+			if value, exists := values[key]; exists {
+				return value
+			}
+			return "${" + key + "}"
+		})
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	if ctx.Err() != nil {
+		return ExecOutput{}, fmt.Errorf("command timed out")
+	}
+
+	var output ExecOutput
+	parseErr := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &output)
+
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if parseErr == nil && output.Error != "" {
+			return output, errors.New(output.Error)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" && errors.As(runErr, &exitErr) {
+			return output, fmt.Errorf("%v: %s", runErr, truncate(message, 200))
+		}
+		return output, runErr
+	}
+	if parseErr != nil {
+		return ExecOutput{}, fmt.Errorf("invalid command output: %v", parseErr)
+	}
+	if !output.Success {
+		if output.Error == "" {
+			output.Error = "command reported failure"
+		}
+		return output, errors.New(output.Error)
+	}
+	return output, nil
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length] + "..."
+}
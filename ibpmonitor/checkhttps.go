@@ -0,0 +1,81 @@
+package ibpmonitor
+
+import (
+	"context"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+func HttpsCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+
+	var MaxConcurrentChecks = 20
+	delayBetweenChecks := 1 * time.Millisecond
+	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
+	checkName := member.checkName("https")
+
+	sem := semaphore.NewWeighted(int64(MaxConcurrentChecks))
+
+	var wg sync.WaitGroup
+
+	for _, service := range member.Services {
+		if !service.servesRPC() {
+			continue
+		}
+
+		for _, endpoint := range service.Endpoints {
+			if !isHttpsEndpoint(endpoint) {
+				continue
+			}
+
+			if err := sem.Acquire(context.Background(), 1); err != nil {
+				log.Printf("Failed to acquire semaphore: %v", err)
+				continue
+			}
+
+			time.Sleep(delayBetweenChecks)
+
+			wg.Add(1)
+			go func(service Service, endpoint string) {
+				defer sem.Release(1)
+				defer wg.Done()
+
+				target, err := parseEndpoint(endpoint)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to parse HTTPS endpoint '%s': %v", endpoint, err)
+					sendResult(checkName, member.ID(), "invalid-hostname", config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				client := newHTTPRPCClient(target, member.address(), time.Duration(connectTimeout)*time.Second)
+
+				if err := probeService(client, service); err != nil {
+					errMsg := fmt.Sprintf("HTTPS check failed (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, true, "", resultsCollectorChannel)
+			}(service, endpoint)
+		}
+	}
+
+	wg.Wait()
+}
+
+func isHttpsEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "https://")
+}
+
+func init() {
+	RegisterCheck("https", HttpsCheck)
+	RegisterEndpointFilter("https", isHttpsEndpoint)
+	RegisterServiceFilter("https", Service.servesRPC)
+}
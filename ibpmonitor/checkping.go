@@ -1,7 +1,6 @@
 package ibpmonitor
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -11,22 +10,13 @@ import (
 	"github.com/go-ping/ping"
 )
 
-type PingResult struct {
-	CheckName  string   `json:"checkname"`
-	ServerName string   `json:"servername"`
-	ResultType string   `json:"resulttype"`
-	Success    bool     `json:"success"`
-	Error      string   `json:"error"`
-	Data       PingData `json:"data"`
-}
-
 type PingData struct {
 	Latency    int64   `json:"latency"`
 	PacketLoss float64 `json:"packetloss"`
 }
 
-func PingCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan string) {
-	checkName := "ping"
+func PingCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+	checkName := member.checkName("ping")
 
 	pingCount := getIntOption(options.ExtraOptions, "PingCount", 30)
 	pingInterval := getIntOption(options.ExtraOptions, "PingInterval", 100)
@@ -36,20 +26,10 @@ func PingCheck(member Member, options config.CheckConfig, resultsCollectorChanne
 	maxPacketLoss := getIntOption(options.ExtraOptions, "MaxPacketLoss", 5)
 	maxLatency := getIntOption(options.ExtraOptions, "MaxLatency", 800)
 
-	pinger, err := ping.NewPinger(member.IPv4Address)
+	pinger, err := ping.NewPinger(member.address())
 	if err != nil {
-		err := fmt.Sprintf("Unable to launch ping: %v\n", err)
-		result := PingResult{
-			CheckName:  checkName,
-			ServerName: member.MemberName,
-			ResultType: "site",
-			Success:    false,
-			Error:      err,
-		}
-
-		finalResultJSON, _ := json.Marshal(result)
-		// log.Printf("Generated JSON (error): %s", finalResultJSON)
-		resultsCollectorChannel <- string(finalResultJSON)
+		errMsg := fmt.Sprintf("Unable to launch ping: %v", err)
+		sendResult(checkName, member.ID(), "", config.ResultTypeSite, false, errMsg, resultsCollectorChannel)
 		return
 	}
 
@@ -62,18 +42,8 @@ func PingCheck(member Member, options config.CheckConfig, resultsCollectorChanne
 
 	err = pinger.Run()
 	if err != nil {
-		err := fmt.Sprintf("Ping failed to run: %v\n", err)
-		result := PingResult{
-			CheckName:  checkName,
-			ServerName: member.MemberName,
-			ResultType: "site",
-			Success:    false,
-			Error:      err,
-		}
-
-		finalResultJSON, _ := json.Marshal(result)
-		// log.Printf("Generated JSON (error): %s", finalResultJSON)
-		resultsCollectorChannel <- string(finalResultJSON)
+		errMsg := fmt.Sprintf("Ping failed to run: %v", err)
+		sendResult(checkName, member.ID(), "", config.ResultTypeSite, false, errMsg, resultsCollectorChannel)
 		return
 	}
 
@@ -82,26 +52,16 @@ func PingCheck(member Member, options config.CheckConfig, resultsCollectorChanne
 	success := stats.PacketLoss <= float64(maxPacketLoss) && stats.AvgRtt.Milliseconds() <= int64(maxLatency) && stats.AvgRtt != 0
 
 	if !success {
-		log.Printf("Member: %s failed ping check - Packet Loss: %v (Max: %v) Latency: %d (Max: %d)", member.MemberName, stats.PacketLoss, float64(maxPacketLoss), stats.AvgRtt.Milliseconds(), int64(maxLatency))
+		log.Printf("Member: %s failed %s check - Packet Loss: %v (Max: %v) Latency: %d (Max: %d)", member.ID(), checkName, stats.PacketLoss, float64(maxPacketLoss), stats.AvgRtt.Milliseconds(), int64(maxLatency))
 	}
 
-	result := PingResult{
-		CheckName:  checkName,
-		ServerName: member.MemberName,
-		ResultType: "site",
-		Success:    success,
-		Data: PingData{
-			Latency:    stats.AvgRtt.Milliseconds(),
-			PacketLoss: stats.PacketLoss,
-		},
+	data := PingData{
+		Latency:    stats.AvgRtt.Milliseconds(),
+		PacketLoss: stats.PacketLoss,
 	}
-
-	finalResultJSON, _ := json.Marshal(result)
-	// log.Printf("Generated JSON: %s", finalResultJSON)
-	resultsCollectorChannel <- string(finalResultJSON)
+	sendResultWithData(checkName, member.ID(), "", config.ResultTypeSite, success, "", data, resultsCollectorChannel)
 }
 
 func init() {
 	RegisterCheck("ping", PingCheck)
-	RegisterResultType("ping", PingResult{})
 }
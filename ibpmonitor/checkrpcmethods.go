@@ -0,0 +1,230 @@
+package ibpmonitor
+
+import (
+	"context"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+type RpcMethodsData struct {
+	Methods     int    `json:"methods"`
+	Listed      int    `json:"listed"`
+	VerifyError string `json:"verify_error,omitempty"`
+}
+
+// defaultDeniedMethods are methods that change node state or leak operator
+// data and must not be reachable on public endpoints.
+var defaultDeniedMethods = []string{
+	"author_rotateKeys",
+	"author_insertKey",
+	"author_removeExtrinsic",
+	"author_hasKey",
+	"author_hasSessionKeys",
+	"system_addReservedPeer",
+	"system_removeReservedPeer",
+	"system_addLogFilter",
+	"system_resetLogFilter",
+	"offchain_*",
+	"sudo_*",
+}
+
+// unsafeProbeRequests are read-only unsafe methods used to find out whether a
+// node that lists unsafe methods actually rejects them. Nodes started with
+// --rpc-methods safe still list every method in rpc_methods.
+var unsafeProbeRequests = map[string][]interface{}{
+	"author_hasKey":            {"0x00", "ibpg"},
+	"author_hasSessionKeys":    {"0x00"},
+	"offchain_localStorageGet": {"PERSISTENT", "0x00"},
+}
+
+func RpcMethodsCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+
+	var MaxConcurrentChecks = 20
+	delayBetweenChecks := 1 * time.Millisecond
+	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
+	deniedMethods := getStringListOption(options.ExtraOptions, "DeniedMethods", defaultDeniedMethods)
+	allowedMethods := getStringListOption(options.ExtraOptions, "AllowedMethods", nil)
+	verifyUnsafe := getBoolOption(options.ExtraOptions, "VerifyUnsafe", true)
+	checkName := member.checkName("rpcmethods")
+
+	sem := semaphore.NewWeighted(int64(MaxConcurrentChecks))
+
+	var wg sync.WaitGroup
+
+	for _, service := range member.Services {
+		if !service.isSubstrate() {
+			continue
+		}
+
+		for _, endpoint := range service.Endpoints {
+			if !isWssEndpoint(endpoint) {
+				continue
+			}
+
+			if err := sem.Acquire(context.Background(), 1); err != nil {
+				log.Printf("Failed to acquire semaphore: %v", err)
+				continue
+			}
+
+			time.Sleep(delayBetweenChecks)
+
+			wg.Add(1)
+			go func(endpoint string) {
+				defer sem.Release(1)
+				defer wg.Done()
+
+				target, err := parseEndpoint(endpoint)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to parse endpoint '%s': %v", endpoint, err)
+					sendResult(checkName, member.ID(), "invalid-hostname", config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				client, closeClient, err := newRPCClient(target, member.address(), time.Duration(connectTimeout)*time.Second)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to connect to endpoint (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+				defer closeClient()
+
+				methods, err := getRpcMethods(client)
+				if err != nil {
+					errMsg := fmt.Sprintf("RPC methods check failed (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				unsafe := []string{}
+				for _, method := range methods {
+					if matchesMethod(method, deniedMethods) || (len(allowedMethods) > 0 && !matchesMethod(method, allowedMethods)) {
+						unsafe = append(unsafe, method)
+					}
+				}
+
+				data := RpcMethodsData{
+					Methods: len(methods),
+					Listed:  len(unsafe),
+				}
+
+				if len(unsafe) > 0 && verifyUnsafe {
+					denied, err := unsafeMethodsDenied(client, methods)
+					if err != nil {
+						data.VerifyError = err.Error()
+					} else if denied {
+						// Listed, but the node rejects unsafe calls. Methods only
+						// failing the allowlist are not covered by that.
+						reachable := []string{}
+						for _, method := range unsafe {
+							if !matchesMethod(method, deniedMethods) {
+								reachable = append(reachable, method)
+							}
+						}
+						unsafe = reachable
+					}
+				}
+
+				if len(unsafe) > 0 {
+					sort.Strings(unsafe)
+					errMsg := fmt.Sprintf("RPC methods check failed (Member: %s URL: '%s' Error: unsafe methods reachable: %s)", member.ID(), endpoint, strings.Join(unsafe, ", "))
+					sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, data, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, true, "", data, resultsCollectorChannel)
+			}(endpoint)
+		}
+	}
+
+	wg.Wait()
+}
+
+func getRpcMethods(client rpcClient) ([]string, error) {
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "rpc_methods",
+		Params:  []interface{}{},
+		ID:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := rpcError(response); err != nil {
+		return nil, fmt.Errorf("rpc_methods failed: %v", err)
+	}
+
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rpc_methods result is invalid")
+	}
+	list, ok := result["methods"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rpc_methods method list is invalid")
+	}
+
+	methods := make([]string, 0, len(list))
+	for _, method := range list {
+		if name, ok := method.(string); ok {
+			methods = append(methods, name)
+		}
+	}
+	return methods, nil
+}
+
+// unsafeMethodsDenied calls a read-only unsafe method and reports whether the
+// node rejected it as unsafe.
+func unsafeMethodsDenied(client rpcClient, methods []string) (bool, error) {
+	for _, method := range methods {
+		params, exists := unsafeProbeRequests[method]
+		if !exists {
+			continue
+		}
+
+		response, err := client.call(JSONRPCRequest{
+			JSONRPC: "2.0",
+			Method:  method,
+			Params:  params,
+			ID:      2,
+		})
+		if err != nil {
+			return false, err
+		}
+
+		rpcErr := rpcError(response)
+		return rpcErr != nil && strings.Contains(strings.ToLower(rpcErr.Error()), "unsafe"), nil
+	}
+
+	return false, fmt.Errorf("no read-only unsafe method available to verify")
+}
+
+// matchesMethod reports whether method is in patterns. A pattern ending in *
+// matches every method with that prefix.
+func matchesMethod(method string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(method, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if method == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterCheck("rpcmethods", RpcMethodsCheck)
+	RegisterEndpointFilter("rpcmethods", isWssEndpoint)
+	RegisterServiceFilter("rpcmethods", Service.isSubstrate)
+}
@@ -2,59 +2,47 @@ package ibpmonitor
 
 import (
 	"crypto/tls"
-	"encoding/json"
 	"ibp-geodns/config"
 	"log"
 	"net"
-	"net/url"
-	"strings"
 	"sync"
 	"time"
 )
 
-type SslResult struct {
-	CheckName   string  `json:"checkname"`
-	MemberName  string  `json:"membername"`
-	EndpointURL string  `json:"endpointurl"`
-	ResultType  string  `json:"resulttype"`
-	Success     bool    `json:"success"`
-	Error       string  `json:"error"`
-	Data        SslData `json:"data"`
-}
-
 type SslData struct {
 	ExpiryTimestamp int64 `json:"expirytimestamp"`
 	DaysUntilExpiry int   `json:"daysuntilexpiry"`
 }
 
-func SslCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan string) {
+func SslCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
 
 	var MaxConcurrentChecks = 20
 
-	checkName := "ssl"
+	checkName := member.checkName("ssl")
 	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
-	uniqueHostnames := make(map[string]bool)
+
+	// Endpoints sharing a hostname and port share a certificate, so each one is
+	// only dialed once and its result is reported for every endpoint key.
+	uniqueHosts := make(map[string]endpointTarget)
+	hostKeys := make(map[string][]string)
 
 	for _, service := range member.Services {
 		for _, endpoint := range service.Endpoints {
-			endpointForParsing := strings.Replace(endpoint, "wss://", "https://", 1)
-
-			u, err := url.Parse(endpointForParsing)
+			target, err := parseEndpoint(endpoint)
 			if err != nil {
-				//log.Printf("Error parsing endpoint '%s' for member %s: %v", endpoint, member.MemberName, err)
+				//log.Printf("Error parsing endpoint '%s' for member %s: %v", endpoint, member.ID(), err)
 				continue
 			}
 
-			hostname := u.Hostname()
-			if hostname != "" {
-				//log.Printf("Extracted hostname '%s' from endpoint '%s'", hostname, endpoint)
-				uniqueHostnames[hostname] = true
+			if _, exists := uniqueHosts[target.Host]; !exists {
+				uniqueHosts[target.Host] = target
 			}
+			hostKeys[target.Host] = appendUnique(hostKeys[target.Host], target.Key)
 		}
 	}
 
-	if len(uniqueHostnames) == 0 {
-		//log.Printf("No valid endpoints found for member %s; skipping SSL check.", member.MemberName)
+	if len(uniqueHosts) == 0 {
+		//log.Printf("No valid endpoints found for member %s; skipping SSL check.", member.ID())
 		return
 	}
 
@@ -62,32 +50,28 @@ func SslCheck(member Member, options config.CheckConfig, resultsCollectorChannel
 	semaphoreChan := make(chan struct{}, MaxConcurrentChecks)
 	delayBetweenChecks := 1 * time.Millisecond
 
-	for hostname := range uniqueHostnames {
+	for host, target := range uniqueHosts {
 		time.Sleep(delayBetweenChecks)
 
 		wg.Add(1)
-		go func(hostname string) {
+		go func(target endpointTarget, keys []string) {
 			defer wg.Done()
 
 			semaphoreChan <- struct{}{}
 			defer func() { <-semaphoreChan }()
 
-			ipAddress := member.IPv4Address
-			tcpConn, err := net.DialTimeout("tcp", net.JoinHostPort(ipAddress, "443"), time.Duration(connectTimeout)*time.Second)
-			if err != nil {
-				log.Printf("SSL check failed for member %s, Hostname %s: TCP Connection error", member.MemberName, hostname)
-
-				result := SslResult{
-					CheckName:   checkName,
-					MemberName:  member.MemberName,
-					EndpointURL: hostname,
-					ResultType:  "endpoint",
-					Success:     false,
-					Error:       "TCP connection error",
-					Data:        SslData{},
+			sendSslResult := func(success bool, errortext string, data interface{}) {
+				for _, key := range keys {
+					sendResultWithData(checkName, member.ID(), key, config.ResultTypeEndpoint, success, errortext, data, resultsCollectorChannel)
 				}
-				resultJSON, _ := json.Marshal(result)
-				resultsCollectorChannel <- string(resultJSON)
+			}
+
+			hostname := target.Hostname
+			ipAddress := member.address()
+			tcpConn, err := net.DialTimeout("tcp", net.JoinHostPort(ipAddress, target.Port), time.Duration(connectTimeout)*time.Second)
+			if err != nil {
+				log.Printf("SSL check failed for member %s, Host %s: TCP Connection error", member.ID(), target.Host)
+				sendSslResult(false, "TCP connection error", nil)
 				return
 			}
 
@@ -98,20 +82,9 @@ func SslCheck(member Member, options config.CheckConfig, resultsCollectorChannel
 
 			err = tlsConn.Handshake()
 			if err != nil {
-				log.Printf("SSL check failed for member %s, Hostname %s: TLS handshake failed", member.MemberName, hostname)
+				log.Printf("SSL check failed for member %s, Host %s: TLS handshake failed", member.ID(), target.Host)
 				tlsConn.Close()
-
-				result := SslResult{
-					CheckName:   checkName,
-					MemberName:  member.MemberName,
-					EndpointURL: hostname,
-					ResultType:  "endpoint",
-					Success:     false,
-					Error:       "TLS handshake failed",
-					Data:        SslData{},
-				}
-				resultJSON, _ := json.Marshal(result)
-				resultsCollectorChannel <- string(resultJSON)
+				sendSslResult(false, "TLS handshake failed", nil)
 				return
 			}
 
@@ -133,26 +106,16 @@ func SslCheck(member Member, options config.CheckConfig, resultsCollectorChannel
 			}
 
 			if !success {
-				log.Printf("SSL check failed for member %s, Hostname %s: Certificate expires in %d days", member.MemberName, hostname, daysUntilExpiry)
+				log.Printf("SSL check failed for member %s, Host %s: Certificate expires in %d days", member.ID(), target.Host, daysUntilExpiry)
 			}
 
-			result := SslResult{
-				CheckName:   checkName,
-				MemberName:  member.MemberName,
-				EndpointURL: hostname,
-				ResultType:  "endpoint",
-				Success:     success,
-				Error:       errortext,
-				Data: SslData{
-					ExpiryTimestamp: expiryTimestamp,
-					DaysUntilExpiry: daysUntilExpiry,
-				},
-			}
-			resultJSON, _ := json.Marshal(result)
-			resultsCollectorChannel <- string(resultJSON)
+			sendSslResult(success, errortext, SslData{
+				ExpiryTimestamp: expiryTimestamp,
+				DaysUntilExpiry: daysUntilExpiry,
+			})
 
 			tlsConn.Close()
-		}(hostname)
+		}(target, hostKeys[host])
 	}
 
 	wg.Wait()
@@ -160,5 +123,4 @@ func SslCheck(member Member, options config.CheckConfig, resultsCollectorChannel
 
 func init() {
 	RegisterCheck("ssl", SslCheck)
-	RegisterResultType("ssl", SslResult{})
 }
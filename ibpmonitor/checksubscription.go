@@ -0,0 +1,206 @@
+package ibpmonitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ibp-geodns/config"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+type SubscriptionData struct {
+	NewHeads               int   `json:"new_heads"`
+	AvgIntervalMs          int64 `json:"avg_interval_ms"`
+	MaxIntervalMs          int64 `json:"max_interval_ms"`
+	FinalizedHeads         int   `json:"finalized_heads,omitempty"`
+	FinalizedAvgIntervalMs int64 `json:"finalized_avg_interval_ms,omitempty"`
+	FinalizedMaxIntervalMs int64 `json:"finalized_max_interval_ms,omitempty"`
+}
+
+// headerTiming collects the arrival times of the headers of one subscription.
+type headerTiming struct {
+	arrivals []time.Time
+}
+
+func (h *headerTiming) intervals() (avg, max time.Duration) {
+	if len(h.arrivals) < 2 {
+		return 0, 0
+	}
+	var total time.Duration
+	for i := 1; i < len(h.arrivals); i++ {
+		interval := h.arrivals[i].Sub(h.arrivals[i-1])
+		total += interval
+		if interval > max {
+			max = interval
+		}
+	}
+	return total / time.Duration(len(h.arrivals)-1), max
+}
+
+func SubscriptionCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
+
+	var MaxConcurrentChecks = 20
+	delayBetweenChecks := 1 * time.Millisecond
+	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
+	window := getIntOption(options.ExtraOptions, "Window", 30)
+	minHeaders := getIntOption(options.ExtraOptions, "MinHeaders", 3)
+	finalizedHeads := getBoolOption(options.ExtraOptions, "FinalizedHeads", false)
+	minFinalizedHeaders := getIntOption(options.ExtraOptions, "MinFinalizedHeaders", 1)
+	checkName := member.checkName("subscription")
+
+	sem := semaphore.NewWeighted(int64(MaxConcurrentChecks))
+
+	var wg sync.WaitGroup
+
+	for _, service := range member.Services {
+		if !service.isSubstrate() {
+			continue
+		}
+
+		for _, endpoint := range service.Endpoints {
+			if !isWssEndpoint(endpoint) {
+				continue
+			}
+
+			if err := sem.Acquire(context.Background(), 1); err != nil {
+				log.Printf("Failed to acquire semaphore: %v", err)
+				continue
+			}
+
+			time.Sleep(delayBetweenChecks)
+
+			wg.Add(1)
+			go func(endpoint string) {
+				defer sem.Release(1)
+				defer wg.Done()
+
+				target, err := parseEndpoint(endpoint)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to parse WSS endpoint '%s': %v", endpoint, err)
+					sendResult(checkName, member.ID(), "invalid-hostname", config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				client, err := newWSRPCClient(target, member.address(), time.Duration(connectTimeout)*time.Second)
+				if err != nil {
+					errMsg := fmt.Sprintf("Failed to connect to WSS endpoint (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+				defer client.conn.Close()
+
+				newHeads, finalized, err := watchHeaders(client, time.Duration(window)*time.Second, minHeaders, finalizedHeads, minFinalizedHeaders)
+
+				avg, max := newHeads.intervals()
+				data := SubscriptionData{
+					NewHeads:      len(newHeads.arrivals),
+					AvgIntervalMs: avg.Milliseconds(),
+					MaxIntervalMs: max.Milliseconds(),
+				}
+				if finalizedHeads {
+					avg, max := finalized.intervals()
+					data.FinalizedHeads = len(finalized.arrivals)
+					data.FinalizedAvgIntervalMs = avg.Milliseconds()
+					data.FinalizedMaxIntervalMs = max.Milliseconds()
+				}
+
+				if err == nil && len(newHeads.arrivals) < minHeaders {
+					err = fmt.Errorf("received %d of %d new heads within %ds", len(newHeads.arrivals), minHeaders, window)
+				}
+				if err == nil && finalizedHeads && len(finalized.arrivals) < minFinalizedHeaders {
+					err = fmt.Errorf("received %d of %d finalized heads within %ds", len(finalized.arrivals), minFinalizedHeaders, window)
+				}
+
+				if err != nil {
+					errMsg := fmt.Sprintf("Subscription check failed (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, data, resultsCollectorChannel)
+					log.Println(errMsg)
+					return
+				}
+
+				sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, true, "", data, resultsCollectorChannel)
+			}(endpoint)
+		}
+	}
+
+	wg.Wait()
+}
+
+// watchHeaders subscribes to new (and optionally finalized) heads and records
+// notifications until the minimum counts are reached or the window ends.
+func watchHeaders(client *wsRPCClient, window time.Duration, minHeaders int, finalizedHeads bool, minFinalizedHeaders int) (*headerTiming, *headerTiming, error) {
+	newHeads := &headerTiming{}
+	finalized := &headerTiming{}
+
+	requests := []JSONRPCRequest{{JSONRPC: "2.0", Method: "chain_subscribeNewHeads", Params: []interface{}{}, ID: 1}}
+	if finalizedHeads {
+		requests = append(requests, JSONRPCRequest{JSONRPC: "2.0", Method: "chain_subscribeFinalizedHeads", Params: []interface{}{}, ID: 2})
+	}
+	for _, request := range requests {
+		if !sendJSONRPCRequest(client.conn, request) {
+			return newHeads, finalized, fmt.Errorf("failed to send %s request", request.Method)
+		}
+	}
+
+	// Subscription ids are matched to the request that created them.
+	subscriptions := make(map[string]*headerTiming)
+	client.conn.SetReadDeadline(time.Now().Add(window))
+
+	for {
+		if len(newHeads.arrivals) >= minHeaders && (!finalizedHeads || len(finalized.arrivals) >= minFinalizedHeaders) {
+			return newHeads, finalized, nil
+		}
+
+		_, message, err := client.conn.ReadMessage()
+		if err != nil {
+			if len(subscriptions) < len(requests) {
+				return newHeads, finalized, fmt.Errorf("subscription was not confirmed: %v", err)
+			}
+			// The window ended, the caller compares the counts.
+			return newHeads, finalized, nil
+		}
+
+		var response struct {
+			ID     int             `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+			Params struct {
+				Subscription json.RawMessage `json:"subscription"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(message, &response); err != nil {
+			return newHeads, finalized, fmt.Errorf("failed to unmarshal subscription message: %v", err)
+		}
+
+		if response.ID > len(requests) {
+			continue
+		}
+		if response.ID != 0 {
+			if len(response.Error) > 0 {
+				return newHeads, finalized, fmt.Errorf("%s failed: %s", requests[response.ID-1].Method, string(response.Error))
+			}
+			timing := newHeads
+			if response.ID == 2 {
+				timing = finalized
+			}
+			subscriptions[string(response.Result)] = timing
+			continue
+		}
+
+		if timing, exists := subscriptions[string(response.Params.Subscription)]; exists {
+			timing.arrivals = append(timing.arrivals, time.Now())
+		}
+	}
+}
+
+func init() {
+	RegisterCheck("subscription", SubscriptionCheck)
+	RegisterEndpointFilter("subscription", isWssEndpoint)
+	RegisterServiceFilter("subscription", Service.isSubstrate)
+}
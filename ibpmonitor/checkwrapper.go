@@ -1,17 +1,17 @@
 package ibpmonitor
 
 import (
-	"encoding/json"
 	"fmt"
 	"ibp-geodns/config"
 	"time"
 )
 
-type EndpointCheck func(member Member, options config.CheckConfig, resultsCollectorChannel chan string, endpointURL string)
+type EndpointCheck func(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult, endpointURL string)
 
 // It issues results to the resultsCollectorChannel based on whether the check is a site or endpoint check.
-func CheckWrapper(checkName string, checkFunc Check, member Member, options config.CheckConfig, resultsCollectorChannel chan string) {
+func CheckWrapper(checkName string, checkFunc Check, member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
 	done := make(chan struct{})
+	resultName := member.checkName(checkName)
 	timer := time.NewTimer(time.Duration(options.Timeout) * time.Second)
 
 	isEndpointCheck := options.CheckType == "endpoint"
@@ -19,16 +19,15 @@ func CheckWrapper(checkName string, checkFunc Check, member Member, options conf
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				errMsg := fmt.Sprintf("%s check failed for member %s: %v", checkName, member.MemberName, r)
+				errMsg := fmt.Sprintf("%s check failed for member %s: %v", resultName, member.ID(), r)
 				if isEndpointCheck {
 					// For endpoint checks, issue a result for every endpoint
-					endpoints := collectEndpoints(member)
-					for _, endpointURL := range endpoints {
-						sendResult(checkName, member.MemberName, endpointURL, "endpoint", false, errMsg, resultsCollectorChannel)
+					for _, key := range collectCheckEndpointKeys(checkName, member) {
+						sendResult(resultName, member.ID(), key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
 					}
 				} else {
 					// For site checks, issue a single result
-					sendResult(checkName, member.MemberName, "", "site", false, errMsg, resultsCollectorChannel)
+					sendResult(resultName, member.ID(), "", config.ResultTypeSite, false, errMsg, resultsCollectorChannel)
 				}
 			}
 			close(done)
@@ -42,35 +41,78 @@ func CheckWrapper(checkName string, checkFunc Check, member Member, options conf
 	case <-done:
 		// Check completed
 	case <-timer.C:
-		errMsg := fmt.Sprintf("%s check for member %s timed out", checkName, member.MemberName)
+		errMsg := fmt.Sprintf("%s check for member %s timed out", resultName, member.ID())
 		if isEndpointCheck {
 			// For endpoint checks, issue a result for every endpoint
-			endpoints := collectEndpoints(member)
-			for _, endpointURL := range endpoints {
-				sendResult(checkName, member.MemberName, endpointURL, "endpoint", false, errMsg, resultsCollectorChannel)
+			for _, key := range collectCheckEndpointKeys(checkName, member) {
+				sendResult(resultName, member.ID(), key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
 			}
 		} else {
 			// For site checks, issue a single result
-			sendResult(checkName, member.MemberName, "", "site", false, errMsg, resultsCollectorChannel)
+			sendResult(resultName, member.ID(), "", config.ResultTypeSite, false, errMsg, resultsCollectorChannel)
 		}
 	}
 }
 
 // sendResult constructs and sends the result to the resultsCollectorChannel.
-func sendResult(checkName, memberName, endpointURL, resultType string, success bool, errMsg string, resultsCollectorChannel chan string) {
-	result := map[string]interface{}{
-		"checkname":  checkName,
-		"membername": memberName,
-		"resulttype": resultType,
-		"success":    success,
-		"error":      errMsg,
-		"data":       map[string]interface{}{},
+func sendResult(checkName, memberName, endpointURL, resultType string, success bool, errMsg string, resultsCollectorChannel chan config.CheckResult) {
+	sendResultWithData(checkName, memberName, endpointURL, resultType, success, errMsg, nil, resultsCollectorChannel)
+}
+
+// sendResultWithData sends a result carrying a check specific payload, which
+// is shown as CheckData on the status page.
+func sendResultWithData(checkName, memberName, endpointURL, resultType string, success bool, errMsg string, data interface{}, resultsCollectorChannel chan config.CheckResult) {
+	result := config.CheckResult{
+		CheckName:  checkName,
+		MemberName: memberName,
+		ResultType: resultType,
+		Success:    success,
+		Error:      errMsg,
+		Data:       data,
+		Timestamp:  time.Now(),
+	}
+	if resultType == config.ResultTypeEndpoint {
+		result.EndpointURL = endpointURL
+	}
+	resultsCollectorChannel <- result
+}
+
+// collectCheckEndpointKeys returns the result keys of the endpoints a check covers.
+func collectCheckEndpointKeys(checkName string, member Member) []string {
+	checksMutex.Lock()
+	keys, exists := endpointKeys[checkName]
+	checksMutex.Unlock()
+	if exists {
+		return keys(member)
+	}
+
+	endpoints := collectCheckEndpoints(checkName, member)
+	checkKeys := make([]string, 0, len(endpoints))
+	for _, endpointURL := range endpoints {
+		checkKeys = append(checkKeys, endpointKey(endpointURL))
 	}
-	if resultType == "endpoint" {
-		result["endpointurl"] = endpointURL
+	return checkKeys
+}
+
+// collectCheckEndpoints collects the unique endpoints of a member covered by a check.
+func collectCheckEndpoints(checkName string, member Member) []string {
+	checksMutex.Lock()
+	filter, exists := endpointFilters[checkName]
+	serviceFilter, serviceFilterExists := serviceFilters[checkName]
+	checksMutex.Unlock()
+
+	filtered := []string{}
+	for _, service := range member.Services {
+		if serviceFilterExists && !serviceFilter(service) {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if !exists || filter(endpoint) {
+				filtered = appendUnique(filtered, endpoint)
+			}
+		}
 	}
-	resultJSON, _ := json.Marshal(result)
-	resultsCollectorChannel <- string(resultJSON)
+	return filtered
 }
 
 // collectEndpoints collects all unique endpoints for a member.
@@ -2,13 +2,10 @@ package ibpmonitor
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"ibp-geodns/config"
 	"log"
-	"net"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -17,15 +14,8 @@ import (
 	"golang.org/x/sync/semaphore"
 )
 
-type WssResult struct {
-	CheckName   string      `json:"checkname"`
-	ServerName  string      `json:"membername"`
-	EndpointURL string      `json:"endpointurl"`
-	ResultType  string      `json:"resulttype"`
-	Success     bool        `json:"success"`
-	Latency     float64     `json:"latency"`
-	Error       string      `json:"error"`
-	Data        interface{} `json:"data,omitempty"`
+type WssData struct {
+	Duration int64 `json:"duration"` // milliseconds from connecting to the last probe response
 }
 
 type JSONRPCRequest struct {
@@ -35,18 +25,27 @@ type JSONRPCRequest struct {
 	ID      int           `json:"id"`
 }
 
-func WssCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan string) {
+func WssCheck(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult) {
 
 	var MaxConcurrentChecks = 20
 	delayBetweenChecks := 1 * time.Millisecond
 	connectTimeout := getIntOption(options.ExtraOptions, "ConnectTimeout", 4)
+	checkName := member.checkName("wss")
 
 	sem := semaphore.NewWeighted(int64(MaxConcurrentChecks))
 
 	var wg sync.WaitGroup
 
 	for _, service := range member.Services {
+		if !service.servesRPC() {
+			continue
+		}
+
 		for _, endpoint := range service.Endpoints {
+			if !isWssEndpoint(endpoint) {
+				continue
+			}
+
 			if err := sem.Acquire(context.Background(), 1); err != nil {
 				log.Printf("Failed to acquire semaphore: %v", err)
 				continue
@@ -59,108 +58,38 @@ func WssCheck(member Member, options config.CheckConfig, resultsCollectorChannel
 				defer sem.Release(1)
 				defer wg.Done()
 
-				u, err := url.Parse(endpoint)
+				target, err := parseEndpoint(endpoint)
 				if err != nil {
 					errMsg := fmt.Sprintf("Failed to parse WSS endpoint '%s': %v", endpoint, err)
-					sendResult("wss", member.MemberName, "invalid-hostname", "endpoint", false, errMsg, resultsCollectorChannel)
+					sendResult(checkName, member.ID(), "invalid-hostname", config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
 					log.Println(errMsg)
 					return
 				}
 
-				hostname := u.Hostname()
-				reconstructedURL := fmt.Sprintf("wss://%s%s", hostname, u.Path)
-
-				dialer := websocket.Dialer{
-					TLSClientConfig: &tls.Config{
-						ServerName:         hostname,
-						InsecureSkipVerify: false,
-					},
-					NetDial: func(network, addr string) (net.Conn, error) {
-
-						return net.DialTimeout(network, net.JoinHostPort(member.IPv4Address, "443"), time.Duration(connectTimeout)*time.Second)
-					},
-					HandshakeTimeout: time.Duration(connectTimeout) * time.Second,
-				}
-
-				c, _, err := dialer.Dial(reconstructedURL, nil)
+				start := time.Now()
+				client, err := newWSRPCClient(target, member.address(), time.Duration(connectTimeout)*time.Second)
 				if err != nil {
-					errMsg := fmt.Sprintf("Failed to connect to WSS endpoint (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
+					errMsg := fmt.Sprintf("Failed to connect to WSS endpoint (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
 					log.Println(errMsg)
 					return
 				}
 
 				defer func() {
-					if err := c.Close(); err != nil {
-						log.Printf("Failed to close connection to (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
+					if err := client.conn.Close(); err != nil {
+						log.Printf("Failed to close connection to (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
 					}
 				}()
 
-				request := JSONRPCRequest{
-					JSONRPC: "2.0",
-					Method:  "chain_getBlockHash",
-					Params:  []interface{}{"latest"},
-					ID:      1,
-				}
-
-				if !sendJSONRPCRequest(c, request) {
-					errMsg := fmt.Sprintf("Failed to send JSON-RPC request to (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-
-				_, _, err = c.ReadMessage()
-				if err != nil {
-					errMsg := fmt.Sprintf("Failed to read JSON-RPC response from (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-
-				isFullArchive, err := checkFullArchive(c)
-				if err != nil {
-					errMsg := fmt.Sprintf("Full archive check failed for (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-				if !isFullArchive {
-					errMsg := fmt.Sprintf("Endpoint is not a full archive node (Member: %s URL: '%s')", member.MemberName, endpoint)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-
-				isCorrectNetwork, err := checkNetwork(c, service.ServiceName)
-				if err != nil {
-					errMsg := fmt.Sprintf("Network check failed for (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-				if !isCorrectNetwork {
-					errMsg := fmt.Sprintf("Endpoint is not on the expected network (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-
-				hasEnoughPeers, isSyncing, err := checkPeers(c)
-				if err != nil {
-					errMsg := fmt.Sprintf("Peers check failed for (Member: %s URL: '%s' Error: %v)", member.MemberName, endpoint, err)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
-					log.Println(errMsg)
-					return
-				}
-				if !hasEnoughPeers || isSyncing {
-					errMsg := fmt.Sprintf("Endpoint has insufficient peers or is syncing (Member: %s URL: '%s')", member.MemberName, endpoint)
-					sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", false, errMsg, resultsCollectorChannel)
+				if err := probeService(client, service); err != nil {
+					errMsg := fmt.Sprintf("WSS check failed (Member: %s URL: '%s' Error: %v)", member.ID(), endpoint, err)
+					sendResult(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, false, errMsg, resultsCollectorChannel)
 					log.Println(errMsg)
 					return
 				}
 
-				sendResult("wss", member.MemberName, hostname+u.Path, "endpoint", true, "", resultsCollectorChannel)
+				data := WssData{Duration: time.Since(start).Milliseconds()}
+				sendResultWithData(checkName, member.ID(), target.Key, config.ResultTypeEndpoint, true, "", data, resultsCollectorChannel)
 			}(service, endpoint)
 		}
 	}
@@ -183,7 +112,59 @@ func sendJSONRPCRequest(c *websocket.Conn, request JSONRPCRequest) bool {
 	return true
 }
 
-func checkFullArchive(c *websocket.Conn) (bool, error) {
+// substrateProbe runs the Substrate JSON-RPC probes.
+func substrateProbe(client rpcClient, service Service) error {
+	request := JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "chain_getBlockHash",
+		Params:  []interface{}{"latest"},
+		ID:      1,
+	}
+
+	if _, err := client.call(request); err != nil {
+		return err
+	}
+
+	if err := checkGenesis(client, service.GenesisHash); err != nil {
+		return fmt.Errorf("genesis check failed: %v", err)
+	}
+
+	if err := checkArchiveState(client, service.ArchiveDepth); err != nil {
+		return fmt.Errorf("archive check failed: %v", err)
+	}
+
+	// The genesis hash identifies the chain, the name is only compared when no
+	// hash is configured.
+	if service.GenesisHash == "" {
+		isCorrectNetwork, err := checkNetwork(client, service.ServiceName)
+		if err != nil {
+			return fmt.Errorf("network check failed: %v", err)
+		}
+		if !isCorrectNetwork {
+			return fmt.Errorf("endpoint is not on the expected network")
+		}
+	}
+
+	if service.SpecName != "" {
+		if err := checkSpecName(client, service.SpecName); err != nil {
+			return fmt.Errorf("runtime check failed: %v", err)
+		}
+	}
+
+	hasEnoughPeers, isSyncing, err := checkPeers(client)
+	if err != nil {
+		return fmt.Errorf("peers check failed: %v", err)
+	}
+	if !hasEnoughPeers || isSyncing {
+		return fmt.Errorf("endpoint has insufficient peers or is syncing")
+	}
+
+	return nil
+}
+
+// checkGenesis verifies the node serves block 0 and, when expectedHash is set,
+// that it is the genesis block of the expected chain.
+func checkGenesis(client rpcClient, expectedHash string) error {
 	request := JSONRPCRequest{
 		JSONRPC: "2.0",
 		Method:  "chain_getBlockHash",
@@ -191,48 +172,130 @@ func checkFullArchive(c *websocket.Conn) (bool, error) {
 		ID:      2,
 	}
 
-	if !sendJSONRPCRequest(c, request) {
-		return false, fmt.Errorf("failed to send chain_getBlockHash request")
+	response, err := client.call(request)
+	if err != nil {
+		return err
+	}
+
+	genesisHash, ok := response["result"].(string)
+	if !ok || genesisHash == "" {
+		return fmt.Errorf("chain_getBlockHash result is invalid")
+	}
+
+	if expectedHash != "" && !strings.EqualFold(genesisHash, expectedHash) {
+		return fmt.Errorf("node reports genesis hash %s instead of expected %s", genesisHash, expectedHash)
+	}
+
+	return nil
+}
+
+// runtimeCodeKey is the well known storage key of the runtime code, ":code".
+const runtimeCodeKey = "0x3a636f6465"
+
+// checkArchiveState queries state at an old block, which pruned nodes have
+// discarded. The block is archiveDepth blocks below the best block, or block 1
+// when no depth is configured.
+func checkArchiveState(client rpcClient, archiveDepth int) error {
+	height := uint64(1)
+	if archiveDepth > 0 {
+		best, err := getHeaderNumber(client, []interface{}{})
+		if err != nil {
+			return err
+		}
+		if best > uint64(archiveDepth) {
+			height = best - uint64(archiveDepth)
+		}
 	}
 
-	_, message, err := c.ReadMessage()
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "chain_getBlockHash",
+		Params:  []interface{}{height},
+		ID:      6,
+	})
 	if err != nil {
-		return false, fmt.Errorf("failed to read chain_getBlockHash response: %v", err)
+		return err
+	}
+	blockHash, ok := response["result"].(string)
+	if !ok || blockHash == "" {
+		return fmt.Errorf("node does not know block %d", height)
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(message, &response); err != nil {
-		return false, fmt.Errorf("failed to unmarshal chain_getBlockHash response: %v", err)
+	response, err = client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "state_getRuntimeVersion",
+		Params:  []interface{}{blockHash},
+		ID:      7,
+	})
+	if err != nil {
+		return err
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("state at block %d is not available: %v", height, err)
 	}
 
-	result, ok := response["result"].(string)
-	if !ok || result == "" {
-		return false, fmt.Errorf("chain_getBlockHash result is invalid")
+	// The hash of the runtime code is read from storage, so it also fails on
+	// nodes that cache runtime versions.
+	response, err = client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "state_getStorageHash",
+		Params:  []interface{}{runtimeCodeKey, blockHash},
+		ID:      8,
+	})
+	if err != nil {
+		return err
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("storage at block %d is not available: %v", height, err)
+	}
+	if codeHash, ok := response["result"].(string); !ok || codeHash == "" {
+		return fmt.Errorf("storage at block %d is not available", height)
 	}
 
-	return true, nil
+	return nil
 }
 
-func checkNetwork(c *websocket.Conn, expectedNetwork string) (bool, error) {
+func checkSpecName(client rpcClient, expectedSpecName string) error {
 	request := JSONRPCRequest{
 		JSONRPC: "2.0",
-		Method:  "system_chain",
+		Method:  "state_getRuntimeVersion",
 		Params:  []interface{}{},
-		ID:      3,
+		ID:      5,
 	}
 
-	if !sendJSONRPCRequest(c, request) {
-		return false, fmt.Errorf("failed to send system_chain request")
+	response, err := client.call(request)
+	if err != nil {
+		return err
 	}
 
-	_, message, err := c.ReadMessage()
-	if err != nil {
-		return false, fmt.Errorf("failed to read system_chain response: %v", err)
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("state_getRuntimeVersion result is invalid")
+	}
+
+	specName, ok := result["specName"].(string)
+	if !ok || specName == "" {
+		return fmt.Errorf("state_getRuntimeVersion specName is invalid")
+	}
+
+	if !strings.EqualFold(specName, expectedSpecName) {
+		return fmt.Errorf("node runs runtime '%s' instead of expected '%s'", specName, expectedSpecName)
+	}
+
+	return nil
+}
+
+func checkNetwork(client rpcClient, expectedNetwork string) (bool, error) {
+	request := JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "system_chain",
+		Params:  []interface{}{},
+		ID:      3,
 	}
 
-	var response map[string]interface{}
-	if err := json.Unmarshal(message, &response); err != nil {
-		return false, fmt.Errorf("failed to unmarshal system_chain response: %v", err)
+	response, err := client.call(request)
+	if err != nil {
+		return false, err
 	}
 
 	chain, ok := response["result"].(string)
@@ -247,7 +310,7 @@ func checkNetwork(c *websocket.Conn, expectedNetwork string) (bool, error) {
 	return true, nil
 }
 
-func checkPeers(c *websocket.Conn) (bool, bool, error) {
+func checkPeers(client rpcClient) (bool, bool, error) {
 	request := JSONRPCRequest{
 		JSONRPC: "2.0",
 		Method:  "system_health",
@@ -255,18 +318,9 @@ func checkPeers(c *websocket.Conn) (bool, bool, error) {
 		ID:      4,
 	}
 
-	if !sendJSONRPCRequest(c, request) {
-		return false, false, fmt.Errorf("failed to send system_health request")
-	}
-
-	_, message, err := c.ReadMessage()
+	response, err := client.call(request)
 	if err != nil {
-		return false, false, fmt.Errorf("failed to read system_health response: %v", err)
-	}
-
-	var response map[string]interface{}
-	if err := json.Unmarshal(message, &response); err != nil {
-		return false, false, fmt.Errorf("failed to unmarshal system_health response: %v", err)
+		return false, false, err
 	}
 
 	result, ok := response["result"].(map[string]interface{})
@@ -288,7 +342,12 @@ func checkPeers(c *websocket.Conn) (bool, bool, error) {
 	return hasEnoughPeers, isSyncing, nil
 }
 
+func isWssEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "wss://") || strings.HasPrefix(endpoint, "ws://")
+}
+
 func init() {
 	RegisterCheck("wss", WssCheck)
-	RegisterResultType("wss", WssResult{})
+	RegisterEndpointFilter("wss", isWssEndpoint)
+	RegisterServiceFilter("wss", Service.servesRPC)
 }
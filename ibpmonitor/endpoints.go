@@ -0,0 +1,68 @@
+package ibpmonitor
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+type endpointTarget struct {
+	URL      string
+	Hostname string
+	Host     string // hostname plus port when the port is not the scheme default
+	Port     string
+	Path     string
+	Key      string // key endpoint results are reported under
+}
+
+// parseEndpoint splits an RPC URL into the name used for TLS and Host headers,
+// the port to dial and the result key. The key never contains the default
+// port, so powerdns can always recover the DNS domain from it.
+func parseEndpoint(endpoint string) (endpointTarget, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpointTarget{}, err
+	}
+
+	hostname := strings.ToLower(u.Hostname())
+	if hostname == "" {
+		return endpointTarget{}, fmt.Errorf("endpoint '%s' has no hostname", endpoint)
+	}
+
+	defaultPort := "443"
+	if u.Scheme == "ws" || u.Scheme == "http" {
+		defaultPort = "80"
+	}
+
+	port := u.Port()
+	host := hostname
+	if port == "" {
+		port = defaultPort
+	} else if port != defaultPort {
+		host = net.JoinHostPort(hostname, port)
+	}
+
+	return endpointTarget{
+		URL:      endpoint,
+		Hostname: hostname,
+		Host:     host,
+		Port:     port,
+		Path:     u.Path,
+		Key:      host + u.Path,
+	}, nil
+}
+
+// endpointKey returns the result key for an endpoint URL.
+func endpointKey(endpoint string) string {
+	target, err := parseEndpoint(endpoint)
+	if err != nil {
+		return "invalid-hostname"
+	}
+	return target.Key
+}
+
+// EndpointKey returns the key results for an endpoint URL are reported under.
+func EndpointKey(endpoint string) string {
+	return endpointKey(endpoint)
+}
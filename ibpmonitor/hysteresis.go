@@ -0,0 +1,66 @@
+package ibpmonitor
+
+import (
+	"ibp-geodns/config"
+	"strings"
+)
+
+// checkStreak tracks consecutive results of one check, so a single failed or
+// successful run does not flip the state reported to powerdns.
+type checkStreak struct {
+	Success bool // debounced state
+	Streak  int  // consecutive raw results matching the last one
+	Last    bool // last raw result
+}
+
+// debounce replaces the success of a raw result with the debounced state and
+// records the raw state next to it. The caller holds nodeResults.mu.
+func (r *IbpMonitor) debounce(nodeResults *NodeResults, key string, result *config.CheckResult) {
+	rawSuccess := result.Success
+
+	if nodeResults.Streaks == nil {
+		nodeResults.Streaks = make(map[string]*checkStreak)
+	}
+	streak, exists := nodeResults.Streaks[key]
+	if !exists {
+		// Without history the first result is taken as is.
+		streak = &checkStreak{Success: rawSuccess, Last: rawSuccess}
+		nodeResults.Streaks[key] = streak
+	}
+
+	if streak.Last == rawSuccess {
+		streak.Streak++
+	} else {
+		streak.Last = rawSuccess
+		streak.Streak = 1
+	}
+
+	failureThreshold, recoveryThreshold := r.thresholds(result.CheckName)
+	if streak.Success && !rawSuccess && streak.Streak >= failureThreshold {
+		streak.Success = false
+	} else if !streak.Success && rawSuccess && streak.Streak >= recoveryThreshold {
+		streak.Success = true
+	}
+
+	result.Success = streak.Success
+	result.RawSuccess = rawSuccess
+	result.Streak = streak.Streak
+}
+
+// thresholds returns the failure and recovery thresholds of a check. IPv6
+// results use the thresholds of the check they belong to.
+func (r *IbpMonitor) thresholds(checkName string) (int, int) {
+	failureThreshold, recoveryThreshold := 1, 1
+	if r.Config == nil {
+		return failureThreshold, recoveryThreshold
+	}
+
+	checkConfig := r.Config.Checks[strings.TrimSuffix(checkName, config.IPv6CheckSuffix)]
+	if checkConfig.FailureThreshold > 0 {
+		failureThreshold = checkConfig.FailureThreshold
+	}
+	if checkConfig.RecoveryThreshold > 0 {
+		recoveryThreshold = checkConfig.RecoveryThreshold
+	}
+	return failureThreshold, recoveryThreshold
+}
@@ -6,17 +6,17 @@ import (
 	"time"
 )
 
-func NewIbpMonitor(members []Member, config *config.Config) *IbpMonitor {
-	resultsChannel := make(chan string, 1024)
+func NewIbpMonitor(members []Member, cfg *config.Config) *IbpMonitor {
+	resultsChannel := make(chan []config.CheckResult, 1024)
 
 	return &IbpMonitor{
 		Members:                 members,
 		HealthStatus:            make(map[string]bool),
 		StopChannel:             make(chan struct{}),
-		Config:                  config,
+		Config:                  cfg,
 		ResultsChannel:          resultsChannel,
 		NodeResults:             make(map[string]*NodeResults),
-		ResultsCollectorChannel: make(chan string, len(members)*len(config.Checks)*10),
+		ResultsCollectorChannel: make(chan config.CheckResult, len(members)*len(cfg.Checks)*10),
 	}
 }
 
@@ -49,7 +49,7 @@ func (r *IbpMonitor) LaunchChecks() {
 	wg.Wait()
 }
 
-func (r *IbpMonitor) Start() chan string {
+func (r *IbpMonitor) Start() chan []config.CheckResult {
 	go r.LaunchChecks()
 	go r.MonitorResults()
 
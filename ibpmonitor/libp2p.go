@@ -0,0 +1,430 @@
+package ibpmonitor
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"ibp-geodns/config"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// The bootnode check only needs to see the remote peer's identity, so this
+// implements just enough of libp2p to get there: multiaddr parsing,
+// multistream-select and the first two messages of the Noise XX handshake.
+
+const (
+	multistreamProtocol = "/multistream/1.0.0"
+	noiseProtocol       = "/noise"
+	noiseProtocolName   = "Noise_XX_25519_ChaChaPoly_SHA256"
+	noiseStaticKeyLabel = "noise-libp2p-static-key:"
+	keyTypeEd25519      = 1
+)
+
+// bootnodeAddr is a parsed libp2p multiaddr.
+type bootnodeAddr struct {
+	Multiaddr string
+	Host      string
+	Port      string
+	Transport string // tcp, ws or wss
+	PeerID    string
+}
+
+// Key returns the key bootnode results are reported under.
+func (a bootnodeAddr) Key() string {
+	return net.JoinHostPort(a.Host, a.Port) + "/p2p/" + a.PeerID
+}
+
+// parseMultiaddr parses multiaddrs like /dns/boot.example.com/tcp/30334/ws/p2p/12D3KooW...
+func parseMultiaddr(multiaddr string) (bootnodeAddr, error) {
+	addr := bootnodeAddr{Multiaddr: multiaddr, Transport: "tcp"}
+
+	parts := strings.Split(strings.Trim(multiaddr, "/"), "/")
+	for i := 0; i < len(parts); i++ {
+		protocol := parts[i]
+		switch protocol {
+		case "ws", "wss":
+			addr.Transport = protocol
+			continue
+		case "tls":
+			// /tls/ws is the newer spelling of /wss
+			continue
+		}
+
+		if i+1 >= len(parts) {
+			return bootnodeAddr{}, fmt.Errorf("multiaddr '%s' is missing a value for /%s", multiaddr, protocol)
+		}
+		value := parts[i+1]
+		i++
+
+		switch protocol {
+		case "ip4", "ip6", "dns", "dns4", "dns6":
+			addr.Host = value
+		case "sni":
+			// the dialed hostname is used for SNI
+		case "tcp":
+			addr.Port = value
+		case "p2p", "ipfs":
+			addr.PeerID = value
+		default:
+			return bootnodeAddr{}, fmt.Errorf("multiaddr '%s' uses unsupported protocol /%s", multiaddr, protocol)
+		}
+	}
+
+	if strings.Contains(multiaddr, "/tls/ws") {
+		addr.Transport = "wss"
+	}
+	if addr.Host == "" || addr.Port == "" || addr.PeerID == "" {
+		return bootnodeAddr{}, fmt.Errorf("multiaddr '%s' needs a host, a tcp port and a peer ID", multiaddr)
+	}
+	return addr, nil
+}
+
+// dialBootnode opens a stream to a bootnode over its transport and family.
+func dialBootnode(addr bootnodeAddr, family string, timeout time.Duration) (io.ReadWriteCloser, error) {
+	hostPort := net.JoinHostPort(addr.Host, addr.Port)
+
+	network := "tcp4"
+	if family == config.FamilyIPv6 {
+		network = "tcp6"
+	}
+
+	if addr.Transport == "tcp" {
+		conn, err := net.DialTimeout(network, hostPort, timeout)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		return conn, nil
+	}
+
+	netDialer := &net.Dialer{Timeout: timeout}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: timeout,
+		NetDialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return netDialer.DialContext(ctx, network, address)
+		},
+	}
+	conn, _, err := dialer.Dial(fmt.Sprintf("%s://%s/", addr.Transport, hostPort), nil)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	return &wsStream{conn: conn}, nil
+}
+
+// wsStream turns the binary messages of a libp2p WebSocket connection into a
+// byte stream.
+type wsStream struct {
+	conn   *websocket.Conn
+	reader io.Reader
+}
+
+func (s *wsStream) Read(p []byte) (int, error) {
+	for {
+		if s.reader == nil {
+			_, reader, err := s.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			s.reader = reader
+		}
+		n, err := s.reader.Read(p)
+		if err == io.EOF {
+			s.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (s *wsStream) Write(p []byte) (int, error) {
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *wsStream) Close() error {
+	return s.conn.Close()
+}
+
+// negotiateNoise agrees on the Noise security protocol with multistream-select.
+func negotiateNoise(stream io.ReadWriter, reader *bufio.Reader) error {
+	message := append(multistreamMessage(multistreamProtocol), multistreamMessage(noiseProtocol)...)
+	if _, err := stream.Write(message); err != nil {
+		return fmt.Errorf("failed to send multistream header: %v", err)
+	}
+
+	for _, expected := range []string{multistreamProtocol, noiseProtocol} {
+		response, err := readMultistreamMessage(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read multistream response: %v", err)
+		}
+		if response != expected {
+			return fmt.Errorf("peer answered '%s' instead of '%s'", response, expected)
+		}
+	}
+	return nil
+}
+
+func multistreamMessage(protocol string) []byte {
+	message := binary.AppendUvarint(nil, uint64(len(protocol)+1))
+	return append(message, protocol+"\n"...)
+}
+
+func readMultistreamMessage(reader *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+	if length == 0 || length > 1024 {
+		return "", fmt.Errorf("invalid multistream message length %d", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(reader, message); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(message), "\n"), nil
+}
+
+// noiseState is the symmetric state of a Noise handshake.
+type noiseState struct {
+	ck, h []byte
+	k     []byte
+	n     uint64
+}
+
+func newNoiseState() *noiseState {
+	// The protocol name is exactly 32 bytes, so it is used as is.
+	h := []byte(noiseProtocolName)
+	state := &noiseState{ck: append([]byte{}, h...), h: h}
+	state.mixHash(nil) // empty prologue
+	return state
+}
+
+func (s *noiseState) mixHash(data []byte) {
+	digest := sha256.New()
+	digest.Write(s.h)
+	digest.Write(data)
+	s.h = digest.Sum(nil)
+}
+
+func (s *noiseState) mixKey(ikm []byte) {
+	temp := hmacSum(sha256.New, s.ck, ikm)
+	s.ck = hmacSum(sha256.New, temp, []byte{0x01})
+	s.k = hmacSum(sha256.New, temp, append(append([]byte{}, s.ck...), 0x02))
+	s.n = 0
+}
+
+func (s *noiseState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if s.k == nil {
+		s.mixHash(ciphertext)
+		return ciphertext, nil
+	}
+
+	aead, err := chacha20poly1305.New(s.k)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], s.n)
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, s.h)
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+func hmacSum(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// noiseHandshakePeerID runs the first two messages of the Noise XX handshake
+// as initiator and returns the peer ID the responder proved it owns.
+func noiseHandshakePeerID(stream io.ReadWriter, reader *bufio.Reader) (string, error) {
+	state := newNoiseState()
+
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return "", err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+
+	// -> e
+	state.mixHash(ephemeralPublic)
+	state.mixHash(nil) // empty payload
+	if _, err := stream.Write(noiseFrame(ephemeralPublic)); err != nil {
+		return "", fmt.Errorf("failed to send noise handshake: %v", err)
+	}
+
+	// <- e, ee, s, es
+	message, err := readNoiseFrame(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read noise handshake: %v", err)
+	}
+	if len(message) < 32+48 {
+		return "", fmt.Errorf("noise handshake message is too short")
+	}
+
+	remoteEphemeral := message[:32]
+	state.mixHash(remoteEphemeral)
+
+	shared, err := curve25519.X25519(ephemeral, remoteEphemeral)
+	if err != nil {
+		return "", err
+	}
+	state.mixKey(shared)
+
+	remoteStatic, err := state.decryptAndHash(message[32 : 32+48])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt noise static key: %v", err)
+	}
+
+	shared, err = curve25519.X25519(ephemeral, remoteStatic)
+	if err != nil {
+		return "", err
+	}
+	state.mixKey(shared)
+
+	payload, err := state.decryptAndHash(message[32+48:])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt noise payload: %v", err)
+	}
+
+	return verifyNoisePayload(payload, remoteStatic)
+}
+
+func noiseFrame(message []byte) []byte {
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(message)))
+	return append(frame, message...)
+}
+
+func readNoiseFrame(reader *bufio.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(reader, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// verifyNoisePayload checks the signature the peer's identity key made over
+// its Noise static key and returns the peer ID of that identity key.
+func verifyNoisePayload(payload, remoteStatic []byte) (string, error) {
+	fields, err := parseProtobuf(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid noise payload: %v", err)
+	}
+	identityKey, signature := fields[1], fields[2]
+	if identityKey == nil || signature == nil {
+		return "", fmt.Errorf("noise payload has no identity key or signature")
+	}
+
+	keyFields, err := parseProtobuf(identityKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid identity key: %v", err)
+	}
+	keyType := new(big.Int).SetBytes(keyFields[1]).Int64()
+	if keyType != keyTypeEd25519 || len(keyFields[2]) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("unsupported identity key type %d", keyType)
+	}
+
+	signed := append([]byte(noiseStaticKeyLabel), remoteStatic...)
+	if !ed25519.Verify(ed25519.PublicKey(keyFields[2]), signed, signature) {
+		return "", fmt.Errorf("identity signature over noise static key is invalid")
+	}
+
+	// Keys of up to 42 bytes are inlined in the peer ID with the identity
+	// multihash.
+	multihash := append([]byte{0x00, byte(len(identityKey))}, identityKey...)
+	return base58Encode(multihash), nil
+}
+
+// parseProtobuf returns the length delimited fields of a protobuf message by
+// field number. Varint fields are returned as big endian bytes.
+func parseProtobuf(message []byte) (map[int][]byte, error) {
+	fields := make(map[int][]byte)
+	for len(message) > 0 {
+		tag, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field tag")
+		}
+		message = message[n:]
+
+		field, wireType := int(tag>>3), tag&7
+		switch wireType {
+		case 0:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", field)
+			}
+			message = message[n:]
+			fields[field] = new(big.Int).SetUint64(value).Bytes()
+		case 2:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return nil, fmt.Errorf("invalid length in field %d", field)
+			}
+			message = message[n:]
+			fields[field] = message[:length]
+			message = message[length:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", wireType, field)
+		}
+	}
+	return fields, nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	base := big.NewInt(58)
+	remainder := new(big.Int)
+
+	encoded := []byte{}
+	for value.Sign() > 0 {
+		value.DivMod(value, base, remainder)
+		encoded = append(encoded, base58Alphabet[remainder.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
@@ -1,5 +1,12 @@
 package ibpmonitor
 
+import (
+	"ibp-geodns/metrics"
+	"log"
+	"reflect"
+	"strings"
+)
+
 func (r *IbpMonitor) AddMember(newMember Member) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -10,9 +17,84 @@ func (r *IbpMonitor) RemoveMember(name string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for i, member := range r.Members {
-		if member.MemberName == name {
+		if member.ID() == name {
 			r.Members = append(r.Members[:i], r.Members[i+1:]...)
 			break
 		}
 	}
+	delete(r.NodeResults, name)
+	metrics.RemoveMember(name)
+}
+
+// UpdateMember replaces the addresses and services of an existing member while
+// keeping its accumulated results. Results for endpoints the member no longer
+// serves are dropped.
+func (r *IbpMonitor) UpdateMember(updatedMember Member) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, member := range r.Members {
+		if member.ID() == updatedMember.ID() {
+			r.Members[i] = updatedMember
+			if nodeResults, exists := r.NodeResults[updatedMember.ID()]; exists {
+				pruneEndpointResults(nodeResults, updatedMember)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// SyncMembers applies a freshly extracted member list to the running monitor.
+func (r *IbpMonitor) SyncMembers(members []Member) {
+	r.mu.Lock()
+	current := make(map[string]Member, len(r.Members))
+	for _, member := range r.Members {
+		current[member.ID()] = member
+	}
+	r.mu.Unlock()
+
+	wanted := make(map[string]bool, len(members))
+	for _, member := range members {
+		wanted[member.ID()] = true
+
+		existing, exists := current[member.ID()]
+		if !exists {
+			log.Printf("Adding member %s to monitor", member.ID())
+			r.AddMember(member)
+		} else if !reflect.DeepEqual(existing, member) {
+			log.Printf("Updating member %s in monitor", member.ID())
+			r.UpdateMember(member)
+		}
+	}
+
+	for name := range current {
+		if !wanted[name] {
+			log.Printf("Removing member %s from monitor", name)
+			r.RemoveMember(name)
+		}
+	}
+}
+
+func pruneEndpointResults(nodeResults *NodeResults, member Member) {
+	keys := make(map[string]bool)
+	for _, endpoint := range collectEndpoints(member) {
+		keys[endpointKey(endpoint)] = true
+	}
+	for _, key := range bootnodeKeys(member) {
+		keys[key] = true
+	}
+
+	nodeResults.mu.Lock()
+	defer nodeResults.mu.Unlock()
+	for endpointURL := range nodeResults.EndpointChecks {
+		if !keys[endpointURL] {
+			delete(nodeResults.EndpointChecks, endpointURL)
+			metrics.RemoveEndpoint(member.ID(), endpointURL)
+		}
+	}
+	for key := range nodeResults.Streaks {
+		if endpointURL, _, isEndpoint := strings.Cut(key, "::"); isEndpoint && !keys[endpointURL] {
+			delete(nodeResults.Streaks, key)
+		}
+	}
 }
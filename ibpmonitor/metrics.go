@@ -0,0 +1,36 @@
+package ibpmonitor
+
+import (
+	"ibp-geodns/config"
+	"ibp-geodns/metrics"
+	"time"
+)
+
+// observeResult exports a debounced result and the measurements it carries.
+func observeResult(result config.CheckResult) {
+	metrics.CheckSuccess.WithLabelValues(result.CheckName, result.MemberName, result.EndpointURL).Set(metrics.BoolValue(result.Success))
+	metrics.CheckRawSuccess.WithLabelValues(result.CheckName, result.MemberName, result.EndpointURL).Set(metrics.BoolValue(result.RawSuccess))
+
+	switch data := result.Data.(type) {
+	case PingData:
+		metrics.PingRTT.WithLabelValues(result.CheckName, result.MemberName).Set((time.Duration(data.Latency) * time.Millisecond).Seconds())
+		metrics.PingPacketLoss.WithLabelValues(result.CheckName, result.MemberName).Set(data.PacketLoss)
+	case SslData:
+		metrics.SslDaysUntilExpiry.WithLabelValues(result.CheckName, result.MemberName, result.EndpointURL).Set(float64(data.DaysUntilExpiry))
+	case WssData:
+		metrics.WssProbeDuration.WithLabelValues(result.CheckName, result.MemberName, result.EndpointURL).Set((time.Duration(data.Duration) * time.Millisecond).Seconds())
+	}
+}
+
+// RegisterMetrics exports the queue lengths and emission counters of the
+// monitor.
+func (r *IbpMonitor) RegisterMetrics() {
+	metrics.RegisterQueue("results_collector", func() int { return len(r.ResultsCollectorChannel) })
+	metrics.RegisterQueue("results", func() int { return len(r.ResultsChannel) })
+	metrics.RegisterQueue("results_pending", func() int {
+		r.pendingMu.Lock()
+		defer r.pendingMu.Unlock()
+		return len(r.pending)
+	})
+	metrics.RegisterResultStats(r.ResultStats)
+}
@@ -0,0 +1,115 @@
+package ibpmonitor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ethereumServiceType = "ethereum"
+
+// ethereumProbe checks an Ethereum JSON-RPC endpoint for the expected chain
+// ID, a non-zero block number and a finished sync. How far the block number
+// is behind other members is left to the block lag check.
+func ethereumProbe(client rpcClient, service Service) error {
+	chainID, err := getEthQuantity(client, "eth_chainId", 1)
+	if err != nil {
+		return fmt.Errorf("chain id check failed: %v", err)
+	}
+	if service.ChainID != 0 && chainID != service.ChainID {
+		return fmt.Errorf("node reports chain id %d instead of expected %d", chainID, service.ChainID)
+	}
+
+	blockNumber, err := getEthQuantity(client, "eth_blockNumber", 2)
+	if err != nil {
+		return fmt.Errorf("block number check failed: %v", err)
+	}
+	if blockNumber == 0 {
+		return fmt.Errorf("node is at block 0")
+	}
+
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "eth_syncing",
+		Params:  []interface{}{},
+		ID:      3,
+	})
+	if err != nil {
+		return fmt.Errorf("sync check failed: %v", err)
+	}
+	if err := rpcError(response); err != nil {
+		return fmt.Errorf("sync check failed: %v", err)
+	}
+	// eth_syncing returns false once synced and a progress object otherwise.
+	if syncing, ok := response["result"].(bool); !ok || syncing {
+		return fmt.Errorf("endpoint is syncing")
+	}
+
+	return nil
+}
+
+// getEthQuantity calls a method returning a hex encoded quantity.
+func getEthQuantity(client rpcClient, method string, id int) (uint64, error) {
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  []interface{}{},
+		ID:      id,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := rpcError(response); err != nil {
+		return 0, err
+	}
+
+	result, _ := response["result"].(string)
+	return parseEthQuantity(method, result)
+}
+
+func parseEthQuantity(method, value string) (uint64, error) {
+	if !strings.HasPrefix(value, "0x") {
+		return 0, fmt.Errorf("%s result is invalid", method)
+	}
+	quantity, err := strconv.ParseUint(strings.TrimPrefix(value, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s result '%s' is invalid: %v", method, value, err)
+	}
+	return quantity, nil
+}
+
+// getEthBlockHeights reads the latest and finalized block numbers of an
+// Ethereum node. Nodes without the finalized block tag report their latest
+// block as finalized.
+func getEthBlockHeights(client rpcClient) (blockObservation, error) {
+	best, err := getEthQuantity(client, "eth_blockNumber", 1)
+	if err != nil {
+		return blockObservation{}, err
+	}
+
+	response, err := client.call(JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  "eth_getBlockByNumber",
+		Params:  []interface{}{"finalized", false},
+		ID:      2,
+	})
+	if err != nil {
+		return blockObservation{}, err
+	}
+	finalized := best
+	if block, ok := response["result"].(map[string]interface{}); ok && rpcError(response) == nil {
+		number, _ := block["number"].(string)
+		if finalized, err = parseEthQuantity("eth_getBlockByNumber", number); err != nil {
+			return blockObservation{}, err
+		}
+	}
+
+	return blockObservation{Best: best, Finalized: finalized, Observed: time.Now()}, nil
+}
+
+func init() {
+	RegisterProbe(ethereumServiceType, ethereumProbe)
+	RegisterServiceTypeAlias("evm", ethereumServiceType)
+	RegisterServiceTypeAlias("eth", ethereumServiceType)
+}
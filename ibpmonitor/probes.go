@@ -0,0 +1,84 @@
+package ibpmonitor
+
+import (
+	"fmt"
+	"ibp-geodns/config"
+	"strings"
+	"sync"
+)
+
+// Probe runs the JSON-RPC probes of a service type against one endpoint.
+type Probe func(client rpcClient, service Service) error
+
+const defaultServiceType = "substrate"
+
+var (
+	probes             = make(map[string]Probe)
+	serviceTypeAliases = make(map[string]string)
+	probesMutex        sync.Mutex
+)
+
+// RegisterProbe registers the probe suite the wss and https checks run for a
+// Configuration.ServiceType.
+func RegisterProbe(serviceType string, probe Probe) {
+	probesMutex.Lock()
+	defer probesMutex.Unlock()
+	probes[strings.ToLower(serviceType)] = probe
+	config.RegisterServiceType(serviceType)
+}
+
+// RegisterServiceTypeAlias makes services of type alias use the probes and
+// checks of serviceType.
+func RegisterServiceTypeAlias(alias, serviceType string) {
+	probesMutex.Lock()
+	defer probesMutex.Unlock()
+	serviceTypeAliases[strings.ToLower(alias)] = strings.ToLower(serviceType)
+	config.RegisterServiceType(alias)
+}
+
+// probeType returns the registered probe suite name of a service. Services
+// without a type use the Substrate probes, unknown types have none and
+// return "".
+func (s Service) probeType() string {
+	probesMutex.Lock()
+	defer probesMutex.Unlock()
+	serviceType := strings.ToLower(s.ServiceType)
+	if serviceType == "" {
+		return defaultServiceType
+	}
+	if target, exists := serviceTypeAliases[serviceType]; exists {
+		serviceType = target
+	}
+	if _, exists := probes[serviceType]; exists {
+		return serviceType
+	}
+	return ""
+}
+
+// isSubstrate reports whether Substrate specific checks apply to a service.
+func (s Service) isSubstrate() bool {
+	return s.probeType() == defaultServiceType
+}
+
+// isEthereum reports whether a service is probed as an Ethereum JSON-RPC
+// service.
+func (s Service) isEthereum() bool {
+	return s.probeType() == ethereumServiceType
+}
+
+// probeService runs the probe suite matching the service type.
+func probeService(client rpcClient, service Service) error {
+	serviceType := service.probeType()
+	probesMutex.Lock()
+	probe := probes[serviceType]
+	probesMutex.Unlock()
+	if probe == nil {
+		return fmt.Errorf("unknown service type '%s'", service.ServiceType)
+	}
+	return probe(client, service)
+}
+
+func init() {
+	RegisterProbe(defaultServiceType, substrateProbe)
+	RegisterServiceTypeAlias("rpc", defaultServiceType)
+}
@@ -1,306 +1,240 @@
 package ibpmonitor
 
 import (
-	"encoding/json"
-	"fmt"
 	"ibp-geodns/config"
+	"ibp-geodns/history"
 	"log"
-	"sync"
 	"time"
 )
 
-var (
-	resultTypes      = make(map[string]interface{})
-	resultTypesMutex sync.Mutex
-)
+const defaultResyncInterval = 60 * time.Second
 
-func RegisterResultType(name string, resultType interface{}) {
-	resultTypesMutex.Lock()
-	defer resultTypesMutex.Unlock()
-	resultTypes[name] = resultType
-}
+func (r *IbpMonitor) MonitorResults() {
+	go r.emitResults()
 
-func GetResultType(name string) (interface{}, bool) {
-	resultTypesMutex.Lock()
-	defer resultTypesMutex.Unlock()
-	resultType, exists := resultTypes[name]
-	return resultType, exists
+	log.Println("Starting to monitor results")
+	for {
+		select {
+		case result := <-r.ResultsCollectorChannel:
+			go r.processResult(result)
+		case <-r.StopChannel:
+			return
+		}
+	}
 }
 
-func (r *IbpMonitor) MonitorResults() {
+// emitResults sends the results that changed since the last emission once a
+// second, and every result of every member once per resync interval. A batch
+// that powerdns does not accept within a second is requeued, so newer results
+// replace it instead of piling up behind it.
+func (r *IbpMonitor) emitResults() {
 	interval := 1 * time.Second
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	log.Println("Starting to monitor results")
+	var lastResync time.Time
 	for {
 		select {
-		case result := <-r.ResultsCollectorChannel:
-			// log.Printf("Received JSON: %s", result)
-			go r.processResult(result)
 		case <-ticker.C:
-			go func() {
-				jsonResults, err := r.sendBatchedResults()
-				if err == nil {
-					if jsonResults != "" {
-						// Non-blocking send using select with default
-						select {
-						case r.ResultsChannel <- jsonResults:
-							// Successfully sent
-						default:
-							// Handle the case where ResultsChannel is full
-							log.Println("ResultsChannel is full. Dropping batched results.")
-						}
-					}
-				} else {
-					log.Printf("Error sending batched results: %v", err)
-				}
-			}()
+		case <-r.StopChannel:
+			return
+		}
+
+		resync := time.Since(lastResync) >= r.resyncInterval()
+		var results []config.CheckResult
+		if resync {
+			results = r.sendBatchedResults()
+		} else {
+			results = r.takePendingResults()
+		}
+		if len(results) == 0 {
+			continue
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case r.ResultsChannel <- results:
+			timer.Stop()
+			r.emittedBatches.Add(1)
+			r.emittedResults.Add(uint64(len(results)))
+			if resync {
+				lastResync = time.Now()
+			}
+		case <-timer.C:
+			r.droppedBatches.Add(1)
+			log.Printf("ResultsChannel is full. Requeueing %d results.", len(results))
+			r.requeueResults(results)
+		case <-r.StopChannel:
+			timer.Stop()
+			return
 		}
 	}
 }
 
-func (r *IbpMonitor) processResult(result string) {
-	var data map[string]interface{}
-	if err := json.Unmarshal([]byte(result), &data); err != nil {
-		log.Printf("Error unmarshaling result: %v", err)
-		return
+func (r *IbpMonitor) resyncInterval() time.Duration {
+	if r.Config != nil && r.Config.ResultsResyncInterval > 0 {
+		return time.Duration(r.Config.ResultsResyncInterval) * time.Second
 	}
+	return defaultResyncInterval
+}
 
-	resultType, ok := data["resulttype"].(string)
-	if !ok {
-		//log.Printf("Error: resulttype not found or not a string in result: %v", data)
-		return
+// ResultStats returns the emission counters of the monitor.
+func (r *IbpMonitor) ResultStats() config.ResultStats {
+	return config.ResultStats{
+		EmittedBatches: r.emittedBatches.Load(),
+		EmittedResults: r.emittedResults.Load(),
+		DroppedBatches: r.droppedBatches.Load(),
 	}
+}
 
-	switch resultType {
-	case "site":
-		r.processSiteResult(data)
-	case "endpoint":
-		r.processEndpointResult(data)
+func (r *IbpMonitor) processResult(result config.CheckResult) {
+	switch result.ResultType {
+	case config.ResultTypeSite:
+		r.processSiteResult(result)
+	case config.ResultTypeEndpoint:
+		r.processEndpointResult(result)
 	default:
-		//log.Printf("Unknown resulttype '%s' in result: %v", resultType, data)
+		log.Printf("Unknown result type '%s' in result of check %s", result.ResultType, result.CheckName)
 	}
 }
 
-func (r *IbpMonitor) processSiteResult(data map[string]interface{}) {
-	memberName, ok := data["servername"].(string)
-	if !ok {
-		log.Printf("Error: ServerName not found or not a string in result: %v", data)
-		return
-	}
-
-	checkName, ok := data["checkname"].(string)
-	if !ok {
-		log.Printf("Error: CheckName not found or not a string in result: %v", data)
-		return
-	}
+func (r *IbpMonitor) getNodeResults(memberName string) *NodeResults {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	nodeResults, exists := r.NodeResults[memberName]
 	if !exists {
-		nodeResults = &NodeResults{Checks: make(map[string]interface{})}
-		r.mu.Lock()
+		nodeResults = &NodeResults{
+			Checks:         make(map[string]config.CheckResult),
+			EndpointChecks: make(map[string]map[string]config.CheckResult),
+		}
 		r.NodeResults[memberName] = nodeResults
-		r.mu.Unlock()
 	}
-
-	nodeResults.mu.Lock()
-	nodeResults.Checks[checkName] = data
-	nodeResults.mu.Unlock()
+	return nodeResults
 }
 
-func (r *IbpMonitor) processEndpointResult(data map[string]interface{}) {
-	// Extract memberName from the result data
-	memberName, ok := data["membername"].(string)
-	if !ok {
-		log.Printf("Error: membername not found or not a string in result: %v", data)
-		return
-	}
+func (r *IbpMonitor) processSiteResult(result config.CheckResult) {
+	nodeResults := r.getNodeResults(result.MemberName)
 
-	// Extract checkName from the result data
-	checkName, ok := data["checkname"].(string)
-	if !ok {
-		log.Printf("Error: checkname not found or not a string in result: %v", data)
-		return
-	}
+	nodeResults.mu.Lock()
+	defer nodeResults.mu.Unlock()
 
-	// Extract endpointURL from the result data
-	endpointURL, ok := data["endpointurl"].(string)
-	if !ok {
-		log.Printf("Error: endpointurl not found or not a string in result: %v", data)
-		return
-	}
+	r.debounce(nodeResults, result.CheckName, &result)
+	history.RecordResult(result)
+	observeResult(result)
+	previous, exists := nodeResults.Checks[result.CheckName]
+	nodeResults.Checks[result.CheckName] = result
 
-	// Lock the IbpMonitor's NodeResults map for thread-safe access
-	r.mu.Lock()
-	nodeResults, exists := r.NodeResults[memberName]
-	if !exists {
-		// Initialize NodeResults for this member if it doesn't exist
-		nodeResults = &NodeResults{
-			Checks:         make(map[string]interface{}),
-			EndpointChecks: make(map[string]map[string]interface{}),
-		}
-		r.NodeResults[memberName] = nodeResults
+	if !exists || resultChanged(previous, result) {
+		r.queueResult(result)
 	}
-	r.mu.Unlock()
+}
+
+func (r *IbpMonitor) processEndpointResult(result config.CheckResult) {
+	nodeResults := r.getNodeResults(result.MemberName)
 
-	// Lock the NodeResults for this member
 	nodeResults.mu.Lock()
 	defer nodeResults.mu.Unlock()
 
-	// Ensure the EndpointChecks map is initialized
-	if nodeResults.EndpointChecks == nil {
-		nodeResults.EndpointChecks = make(map[string]map[string]interface{})
-	}
-
 	// Ensure the map for this endpointURL exists
-	if nodeResults.EndpointChecks[endpointURL] == nil {
-		nodeResults.EndpointChecks[endpointURL] = make(map[string]interface{})
+	if nodeResults.EndpointChecks[result.EndpointURL] == nil {
+		nodeResults.EndpointChecks[result.EndpointURL] = make(map[string]config.CheckResult)
 	}
 
-	// Store the check result
-	nodeResults.EndpointChecks[endpointURL][checkName] = data
+	r.debounce(nodeResults, result.EndpointURL+"::"+result.CheckName, &result)
+	history.RecordResult(result)
+	observeResult(result)
+	previous, exists := nodeResults.EndpointChecks[result.EndpointURL][result.CheckName]
+	nodeResults.EndpointChecks[result.EndpointURL][result.CheckName] = result
 
-	// Mark the check as completed in nodeResults.Checks
-	nodeResults.Checks[checkName] = true
+	if !exists || resultChanged(previous, result) {
+		r.queueResult(result)
+	}
+}
 
-	// Log the updated NodeResults for debugging
-	//log.Printf("Updated NodeResults for member '%s': %+v", memberName, nodeResults)
+// resultChanged reports whether a result has to be sent before the next
+// resync. Failing results are always sent, since powerdns holds a member down
+// for MinimumOfflineTime after its last failure. Changes to check data and
+// streaks of passing results wait for the resync.
+func resultChanged(previous, result config.CheckResult) bool {
+	if !result.Success || !result.RawSuccess {
+		return true
+	}
+	return previous.Success != result.Success ||
+		previous.RawSuccess != result.RawSuccess ||
+		previous.Error != result.Error
 }
 
-func (r *IbpMonitor) sendBatchedResults() (string, error) {
-	// Step 1: Lock and copy NodeResults
-	r.mu.Lock()
-	copiedNodeResults := make(map[string]*NodeResults, len(r.NodeResults))
-	for memberName, nodeResult := range r.NodeResults {
-		// Copy Checks
-		copiedChecks := make(map[string]interface{}, len(nodeResult.Checks))
-		for checkName, checkData := range nodeResult.Checks {
-			copiedChecks[checkName] = checkData
-		}
+func pendingKey(result config.CheckResult) string {
+	return result.MemberName + " " + result.ResultType + " " + result.EndpointURL + "::" + result.CheckName
+}
 
-		// Copy EndpointChecks
-		copiedEndpointChecks := make(map[string]map[string]interface{}, len(nodeResult.EndpointChecks))
-		for endpointURL, checks := range nodeResult.EndpointChecks {
-			copiedChecksMap := make(map[string]interface{}, len(checks))
-			for checkName, checkData := range checks {
-				copiedChecksMap[checkName] = checkData
-			}
-			copiedEndpointChecks[endpointURL] = copiedChecksMap
-		}
+// queueResult records a result for the next emission, replacing any older
+// result of the same check.
+func (r *IbpMonitor) queueResult(result config.CheckResult) {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
 
-		// Assign copied NodeResults
-		copiedNodeResults[memberName] = &NodeResults{
-			Checks:         copiedChecks,
-			EndpointChecks: copiedEndpointChecks,
-		}
+	if r.pending == nil {
+		r.pending = make(map[string]config.CheckResult)
 	}
-	r.mu.Unlock() // Unlock early to allow other operations
+	r.pending[pendingKey(result)] = result
+}
 
-	// Step 3: Initialize batched results
-	siteResults := config.SiteResults{
-		ResultType: "site",
-		Members:    make(map[string]map[string]config.SiteCheckResult),
-	}
+// requeueResults puts back results of a batch that was not sent, unless a
+// newer result of the same check is already queued.
+func (r *IbpMonitor) requeueResults(results []config.CheckResult) {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
 
-	endpointResults := config.EndpointResults{
-		ResultType: "endpoint",
-		Endpoint:   make(map[string]map[string]map[string]config.EndpointCheckResult),
+	if r.pending == nil {
+		r.pending = make(map[string]config.CheckResult)
 	}
+	for _, result := range results {
+		key := pendingKey(result)
+		if _, exists := r.pending[key]; !exists {
+			r.pending[key] = result
+		}
+	}
+}
 
-	// Step 4: Process copiedNodeResults
-	for memberName, nodeResults := range copiedNodeResults {
-		// Process site checks
-		for checkName, resultData := range nodeResults.Checks {
-			// Initialize member map if not present
-			if siteResults.Members[memberName] == nil {
-				siteResults.Members[memberName] = make(map[string]config.SiteCheckResult)
-			}
-
-			// Type assertion for resultData
-			resultMap, ok := resultData.(map[string]interface{})
-			if !ok {
-				continue // Skip if type assertion fails
-			}
+func (r *IbpMonitor) takePendingResults() []config.CheckResult {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
 
-			// Safely extract fields with type checks
-			success, ok := resultMap["success"].(bool)
-			if !ok {
-				success = false // Default value if type assertion fails
-			}
-			checkError, ok := resultMap["error"].(string)
-			if !ok {
-				checkError = "" // Default value if type assertion fails
-			}
-			checkData, ok := resultMap["data"].(map[string]interface{})
-			if !ok {
-				checkData = nil // Default value if type assertion fails
-			}
+	results := make([]config.CheckResult, 0, len(r.pending))
+	for _, result := range r.pending {
+		results = append(results, result)
+	}
+	r.pending = nil
+	return results
+}
 
-			// Assign to siteResults
-			siteResults.Members[memberName][checkName] = config.SiteCheckResult{
-				CheckName:  checkName,
-				Success:    success,
-				CheckError: checkError,
-				CheckData:  checkData,
-			}
-		}
+// sendBatchedResults returns the latest result of every check of every member
+// and clears the queued changes, which the full batch already contains.
+func (r *IbpMonitor) sendBatchedResults() []config.CheckResult {
+	r.takePendingResults()
 
-		// Process endpoint checks
-		for endpointURL, checks := range nodeResults.EndpointChecks {
-			// Initialize maps if not present
-			if endpointResults.Endpoint[endpointURL] == nil {
-				endpointResults.Endpoint[endpointURL] = make(map[string]map[string]config.EndpointCheckResult)
-			}
-			if endpointResults.Endpoint[endpointURL][memberName] == nil {
-				endpointResults.Endpoint[endpointURL][memberName] = make(map[string]config.EndpointCheckResult)
-			}
+	r.mu.Lock()
+	nodeResults := make([]*NodeResults, 0, len(r.NodeResults))
+	for _, nodeResult := range r.NodeResults {
+		nodeResults = append(nodeResults, nodeResult)
+	}
+	r.mu.Unlock() // Unlock early to allow other operations
 
-			for checkName, resultData := range checks {
-				// Type assertion for resultData
-				resultMap, ok := resultData.(map[string]interface{})
-				if !ok {
-					continue // Skip if type assertion fails
-				}
-
-				// Safely extract fields with type checks
-				success, ok := resultMap["success"].(bool)
-				if !ok {
-					success = false // Default value if type assertion fails
-				}
-				checkError, ok := resultMap["error"].(string)
-				if !ok {
-					checkError = "" // Default value if type assertion fails
-				}
-				checkData, ok := resultMap["data"].(map[string]interface{})
-				if !ok {
-					checkData = nil // Default value if type assertion fails
-				}
-
-				// Assign to endpointResults
-				endpointResults.Endpoint[endpointURL][memberName][checkName] = config.EndpointCheckResult{
-					CheckName:  checkName,
-					Success:    success,
-					CheckError: checkError,
-					CheckData:  checkData,
-				}
+	results := []config.CheckResult{}
+	for _, nodeResult := range nodeResults {
+		nodeResult.mu.Lock()
+		for _, result := range nodeResult.Checks {
+			results = append(results, result)
+		}
+		for _, checks := range nodeResult.EndpointChecks {
+			for _, result := range checks {
+				results = append(results, result)
 			}
 		}
+		nodeResult.mu.Unlock()
 	}
 
-	// Step 5: Marshal the batched results to JSON
-	siteResultsJSON, err := json.Marshal(siteResults)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling site results: %v", err)
-	}
-	endpointResultsJSON, err := json.Marshal(endpointResults)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling endpoint results: %v", err)
-	}
-
-	// Step 6: Concatenate the JSON results
-	jsonResults := string(siteResultsJSON) + "\n" + string(endpointResultsJSON)
-
-	// Step 7: Return the concatenated results
-	return jsonResults, nil
+	return results
 }
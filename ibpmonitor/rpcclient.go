@@ -0,0 +1,155 @@
+package ibpmonitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// rpcClient sends a JSON-RPC request and returns the decoded response, so the
+// same probes can run over WebSocket and HTTP.
+type rpcClient interface {
+	call(request JSONRPCRequest) (map[string]interface{}, error)
+}
+
+// rpcError returns the error of a JSON-RPC response, if any.
+func rpcError(response map[string]interface{}) error {
+	rpcErr, exists := response["error"]
+	if !exists || rpcErr == nil {
+		return nil
+	}
+	if errMap, ok := rpcErr.(map[string]interface{}); ok {
+		if message, ok := errMap["message"].(string); ok {
+			if data, ok := errMap["data"].(string); ok && data != "" {
+				return fmt.Errorf("%s: %s", message, data)
+			}
+			return fmt.Errorf("%s", message)
+		}
+	}
+	return fmt.Errorf("%v", rpcErr)
+}
+
+type wsRPCClient struct {
+	conn *websocket.Conn
+}
+
+// newWSRPCClient opens a WebSocket connection to target through ipAddress
+// instead of resolving the endpoint hostname.
+func newWSRPCClient(target endpointTarget, ipAddress string, timeout time.Duration) (*wsRPCClient, error) {
+	dialer := websocket.Dialer{
+		TLSClientConfig: &tls.Config{
+			ServerName:         target.Hostname,
+			InsecureSkipVerify: false,
+		},
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, net.JoinHostPort(ipAddress, target.Port), timeout)
+		},
+		HandshakeTimeout: timeout,
+	}
+
+	conn, _, err := dialer.Dial(fmt.Sprintf("wss://%s%s", target.Host, target.Path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wsRPCClient{conn: conn}, nil
+}
+
+// newRPCClient returns a client matching the endpoint scheme along with a
+// function that releases it.
+func newRPCClient(target endpointTarget, ipAddress string, timeout time.Duration) (rpcClient, func(), error) {
+	if strings.HasPrefix(target.URL, "https://") {
+		return newHTTPRPCClient(target, ipAddress, timeout), func() {}, nil
+	}
+
+	client, err := newWSRPCClient(target, ipAddress, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, func() { client.conn.Close() }, nil
+}
+
+func (c *wsRPCClient) call(request JSONRPCRequest) (map[string]interface{}, error) {
+	if !sendJSONRPCRequest(c.conn, request) {
+		return nil, fmt.Errorf("failed to send %s request", request.Method)
+	}
+
+	_, message, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %v", request.Method, err)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(message, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s response: %v", request.Method, err)
+	}
+
+	return response, nil
+}
+
+type httpRPCClient struct {
+	client *http.Client
+	url    string
+}
+
+// newHTTPRPCClient returns a client for target that connects to ipAddress
+// instead of resolving the endpoint hostname.
+func newHTTPRPCClient(target endpointTarget, ipAddress string, timeout time.Duration) *httpRPCClient {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	return &httpRPCClient{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, net.JoinHostPort(ipAddress, target.Port))
+				},
+				TLSClientConfig: &tls.Config{
+					ServerName:         target.Hostname,
+					InsecureSkipVerify: false,
+				},
+				TLSHandshakeTimeout: timeout,
+				DisableKeepAlives:   true,
+			},
+		},
+		url: fmt.Sprintf("https://%s%s", target.Host, target.Path),
+	}
+}
+
+func (c *httpRPCClient) call(request JSONRPCRequest) (map[string]interface{}, error) {
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s request: %v", request.Method, err)
+	}
+
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %v", request.Method, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %v", request.Method, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s request returned HTTP %s", request.Method, resp.Status)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s response: %v", request.Method, err)
+	}
+
+	return response, nil
+}
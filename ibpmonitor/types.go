@@ -3,6 +3,7 @@ package ibpmonitor
 import (
 	"ibp-geodns/config"
 	"sync"
+	"sync/atomic"
 )
 
 type RpcServerOptions struct {
@@ -17,11 +18,12 @@ type RpcServer struct {
 	Options RpcServerOptions
 }
 
-type Check func(member Member, options config.CheckConfig, resultsCollectorChannel chan string)
+type Check func(member Member, options config.CheckConfig, resultsCollectorChannel chan config.CheckResult)
 
 type NodeResults struct {
-	Checks         map[string]interface{}            // For site-wide checks
-	EndpointChecks map[string]map[string]interface{} // For endpoint-specific checks
+	Checks         map[string]config.CheckResult            // For site-wide checks
+	EndpointChecks map[string]map[string]config.CheckResult // For endpoint-specific checks
+	Streaks        map[string]*checkStreak                  // Consecutive results per check
 	mu             sync.Mutex
 }
 
@@ -31,20 +33,68 @@ type IbpMonitor struct {
 	HealthStatus            map[string]bool
 	StopChannel             chan struct{}
 	Config                  *config.Config
-	ResultsChannel          chan string
-	ResultsCollectorChannel chan string
+	ResultsChannel          chan []config.CheckResult
+	ResultsCollectorChannel chan config.CheckResult
 	NodeResults             map[string]*NodeResults
 	InitialResultsProcessed bool
+
+	pendingMu      sync.Mutex
+	pending        map[string]config.CheckResult // results changed since the last emission
+	emittedBatches atomic.Uint64
+	emittedResults atomic.Uint64
+	droppedBatches atomic.Uint64
 }
 
 type Service struct {
-	ServiceName string   `json:"service_name"`
-	Endpoints   []string `json:"endpoints"`
+	ServiceName  string   `json:"service_name"`
+	ServiceType  string   `json:"service_type,omitempty"`
+	ChainID      uint64   `json:"chain_id,omitempty"`
+	GenesisHash  string   `json:"genesis_hash,omitempty"`
+	SpecName     string   `json:"spec_name,omitempty"`
+	ArchiveDepth int      `json:"archive_depth,omitempty"`
+	Endpoints    []string `json:"endpoints"`
+	BootNodes    []string `json:"boot_nodes,omitempty"`
 }
 
 type Member struct {
 	MemberName  string    `json:"member_name"`
+	SiteName    string    `json:"site_name,omitempty"`
 	IPv4Address string    `json:"ipv4_address"`
 	IPv6Address string    `json:"ipv6_address"`
 	Services    []Service `json:"services"`
+	family      string
+}
+
+// ID identifies the member site in results, see config.SiteKey.
+func (m Member) ID() string {
+	return config.SiteKey(m.MemberName, m.SiteName)
+}
+
+// addressFamilies returns a copy of the member for every address family it
+// should be checked over. IPv6 is only checked when an address is configured.
+func (m Member) addressFamilies() []Member {
+	ipv4 := m
+	ipv4.family = config.FamilyIPv4
+	members := []Member{ipv4}
+
+	if m.IPv6Address != "" {
+		ipv6 := m
+		ipv6.family = config.FamilyIPv6
+		members = append(members, ipv6)
+	}
+	return members
+}
+
+// address returns the IP a check should connect to for the member's family.
+func (m Member) address() string {
+	if m.family == config.FamilyIPv6 {
+		return m.IPv6Address
+	}
+	return m.IPv4Address
+}
+
+// checkName returns the name results of a check are reported under for the
+// member's family.
+func (m Member) checkName(name string) string {
+	return config.FamilyCheckName(name, m.family)
 }
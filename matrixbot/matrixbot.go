@@ -3,12 +3,16 @@ package matrixbot
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
+const requestTimeout = 30 * time.Second
+
 type MatrixBot struct {
 	Client *mautrix.Client
 	RoomID id.RoomID
@@ -19,6 +23,7 @@ func NewMatrixBot(homeserverURL, username, password, roomID string) (*MatrixBot,
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
+	client.Client = &http.Client{Timeout: requestTimeout}
 
 	loginReq := mautrix.ReqLogin{
 		Type: mautrix.AuthTypePassword,
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"ibp-geodns/config"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "geodns"
+
+var (
+	CheckSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "check_success",
+		Help:      "Debounced result of the last check run, 1 for success.",
+	}, []string{"check", "member", "endpoint"})
+
+	CheckRawSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "check_raw_success",
+		Help:      "Result of the last check run before debouncing, 1 for success.",
+	}, []string{"check", "member", "endpoint"})
+
+	PingRTT = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ping_rtt_seconds",
+		Help:      "Average round trip time of the last ping check.",
+	}, []string{"check", "member"})
+
+	PingPacketLoss = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ping_packet_loss_percent",
+		Help:      "Packet loss of the last ping check.",
+	}, []string{"check", "member"})
+
+	SslDaysUntilExpiry = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ssl_days_until_expiry",
+		Help:      "Days until the certificate served for an endpoint expires.",
+	}, []string{"check", "member", "endpoint"})
+
+	WssProbeDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "wss_probe_duration_seconds",
+		Help:      "Duration of the last successful WSS probe of an endpoint.",
+	}, []string{"check", "member", "endpoint"})
+
+	DNSLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_lookups_total",
+		Help:      "DNS lookups by domain, query type, member answered with and outcome.",
+	}, []string{"domain", "qtype", "member", "outcome"})
+
+	DNSLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dns_lookup_duration_seconds",
+		Help:      "Time taken to answer DNS lookups.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"qtype"})
+
+	MemberOverride = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "member_override",
+		Help:      "1 when a member is taken out of all rotations through the API.",
+	}, []string{"member"})
+)
+
+var (
+	queuesMutex sync.Mutex
+	queues      = make(map[string]func() int)
+	queueDesc   = prometheus.NewDesc(namespace+"_queue_length", "Items waiting in an internal queue.", []string{"queue"}, nil)
+)
+
+// queueCollector reports the registered queue lengths at scrape time.
+type queueCollector struct{}
+
+func (queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDesc
+}
+
+func (queueCollector) Collect(ch chan<- prometheus.Metric) {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	for name, length := range queues {
+		ch <- prometheus.MustNewConstMetric(queueDesc, prometheus.GaugeValue, float64(length()), name)
+	}
+}
+
+func init() {
+	prometheus.MustRegister(queueCollector{})
+}
+
+// RegisterQueue reports the length of a queue, usually len of a channel, as
+// geodns_queue_length.
+func RegisterQueue(name string, length func() int) {
+	queuesMutex.Lock()
+	defer queuesMutex.Unlock()
+	queues[name] = length
+}
+
+// RegisterResultStats exports the result emission counters of the monitor.
+func RegisterResultStats(stats func() config.ResultStats) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "result_batches_emitted_total",
+		Help:      "Result batches sent from the monitor to powerdns.",
+	}, func() float64 { return float64(stats().EmittedBatches) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "results_emitted_total",
+		Help:      "Results sent from the monitor to powerdns.",
+	}, func() float64 { return float64(stats().EmittedResults) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "result_batches_dropped_total",
+		Help:      "Result batches requeued because powerdns did not accept them in time.",
+	}, func() float64 { return float64(stats().DroppedBatches) })
+}
+
+// RemoveMember drops the check series of a member that is no longer monitored.
+func RemoveMember(member string) {
+	labels := prometheus.Labels{"member": member}
+	for _, vec := range []*prometheus.GaugeVec{CheckSuccess, CheckRawSuccess, PingRTT, PingPacketLoss, SslDaysUntilExpiry, WssProbeDuration} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// RemoveEndpoint drops the check series of an endpoint a member no longer serves.
+func RemoveEndpoint(member, endpoint string) {
+	labels := prometheus.Labels{"member": member, "endpoint": endpoint}
+	for _, vec := range []*prometheus.GaugeVec{CheckSuccess, CheckRawSuccess, SslDaysUntilExpiry, WssProbeDuration} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+func BoolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -2,9 +2,13 @@ package powerdns
 
 import (
 	"encoding/json"
+	"ibp-geodns/config"
+	"ibp-geodns/consensus"
+	"ibp-geodns/history"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 )
 
 func apiHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +31,18 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		res = listMembers()
 	case "status":
 		res = status(req)
+	case "validation":
+		res = Response{Result: config.GetValidationReport()}
+	case "resultStats":
+		res = Response{Result: getResultStats()}
+	case "history":
+		res = resultHistory(req)
+	case "transitions":
+		res = transitionHistory(req)
+	case "sla":
+		res = slaReport(req)
+	case "consensus":
+		res = Response{Result: consensus.Peers()}
 	default:
 		http.Error(w, "Method not supported", http.StatusNotImplemented)
 		return
@@ -42,10 +58,7 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func enableMember(req ApiRequest) Response {
-	authValue := configData.AuthKey[req.Details]
-	rootAuthValue := configData.AuthKey["root"]
-
-	if (req.AuthKey != authValue) && (req.AuthKey != rootAuthValue) {
+	if !authorized(req.AuthKey, req.Details) {
 		return Response{
 			Result: "Unauthorized access",
 		}
@@ -54,15 +67,23 @@ func enableMember(req ApiRequest) Response {
 	memberName := req.Details
 	success := 0
 
+	mu.Lock()
 	for i := range powerDNSConfigs {
 		for name, member := range powerDNSConfigs[i].Members {
-			if name == memberName {
+			if member.MemberName == memberName || name == memberName {
 				member.Override = false
 				powerDNSConfigs[i].Members[name] = member
 				success = 1
 			}
 		}
 	}
+	if success == 1 {
+		updateOverrideMetrics()
+	}
+	mu.Unlock()
+	if success == 1 {
+		history.RecordOverride(history.Override{Timestamp: time.Now(), MemberName: memberName, Override: false})
+	}
 
 	response := Response{
 		Result: success,
@@ -72,10 +93,7 @@ func enableMember(req ApiRequest) Response {
 }
 
 func disableMember(req ApiRequest) Response {
-	authValue := configData.AuthKey[req.Details]
-	rootAuthValue := configData.AuthKey["root"]
-
-	if (req.AuthKey != authValue) && (req.AuthKey != rootAuthValue) {
+	if !authorized(req.AuthKey, req.Details) {
 		return Response{
 			Result: "Unauthorized access",
 		}
@@ -84,15 +102,23 @@ func disableMember(req ApiRequest) Response {
 	memberName := req.Details
 	success := 0
 
+	mu.Lock()
 	for i := range powerDNSConfigs {
 		for name, member := range powerDNSConfigs[i].Members {
-			if name == memberName {
+			if member.MemberName == memberName || name == memberName {
 				member.Override = true
 				powerDNSConfigs[i].Members[name] = member
 				success = 1
 			}
 		}
 	}
+	if success == 1 {
+		updateOverrideMetrics()
+	}
+	mu.Unlock()
+	if success == 1 {
+		history.RecordOverride(history.Override{Timestamp: time.Now(), MemberName: memberName, Override: true})
+	}
 
 	response := Response{
 		Result: success,
@@ -101,10 +127,17 @@ func disableMember(req ApiRequest) Response {
 	return response
 }
 
+// authorized reports whether key is the root key or the key of the member
+// named by target, which is a member name or a site key.
+func authorized(key, target string) bool {
+	memberName, _, _ := strings.Cut(target, "@")
+	return key == configData.AuthKey[memberName] || key == configData.AuthKey["root"]
+}
+
 func listMembers() Response {
 	uniqueMembersMap := make(map[string]Member)
 
-	for _, dnsConfig := range powerDNSConfigs {
+	for _, dnsConfig := range copyConfigs() {
 		for memberName, member := range dnsConfig.Members {
 			uniqueMembersMap[memberName] = member
 		}
@@ -115,7 +148,10 @@ func listMembers() Response {
 		uniqueMembers = append(uniqueMembers, member)
 	}
 	sort.Slice(uniqueMembers, func(i, j int) bool {
-		return uniqueMembers[i].MemberName < uniqueMembers[j].MemberName
+		if uniqueMembers[i].MemberName != uniqueMembers[j].MemberName {
+			return uniqueMembers[i].MemberName < uniqueMembers[j].MemberName
+		}
+		return uniqueMembers[i].SiteName < uniqueMembers[j].SiteName
 	})
 
 	response := Response{
@@ -126,40 +162,18 @@ func listMembers() Response {
 }
 
 func status(req ApiRequest) Response {
-	filteredConfigs := make([]DNS, len(powerDNSConfigs))
-	for i, dns := range powerDNSConfigs {
-		filteredConfigs[i].Domain = dns.Domain
-		filteredConfigs[i].Members = make(map[string]Member)
-
-		for memberName, member := range dns.Members {
-			copiedMember := Member{
-				MemberName: member.MemberName,
-				IPv4:       member.IPv4,
-				IPv6:       member.IPv6,
-				Latitude:   member.Latitude,
-				Longitude:  member.Longitude,
-				Override:   member.Override,
-				Results:    make(map[string]Result),
-			}
-
-			for checkKey, check := range member.Results {
-				copiedMember.Results[checkKey] = check
-			}
-
-			filteredConfigs[i].Members[memberName] = copiedMember
-		}
-	}
+	filteredConfigs := copyConfigs()
 
 	if req.Details != "" {
 		memberName := req.Details
 		for i := range filteredConfigs {
-			if member, exists := filteredConfigs[i].Members[memberName]; exists {
-				filteredConfigs[i].Members = map[string]Member{
-					memberName: member,
+			selectedMembers := map[string]Member{}
+			for siteKey, member := range filteredConfigs[i].Members {
+				if siteKey == memberName || member.MemberName == memberName {
+					selectedMembers[siteKey] = member
 				}
-			} else {
-				filteredConfigs[i].Members = map[string]Member{}
 			}
+			filteredConfigs[i].Members = selectedMembers
 		}
 	}
 
@@ -189,3 +203,52 @@ func status(req ApiRequest) Response {
 
 	return response
 }
+
+func historyQuery(req ApiRequest) history.Query {
+	return history.Query{
+		MemberName: req.Details,
+		CheckName:  req.Check,
+		Domain:     req.Domain,
+		From:       req.From,
+		To:         req.To,
+		Limit:      req.Limit,
+	}
+}
+
+func resultHistory(req ApiRequest) Response {
+	results, err := history.Results(historyQuery(req))
+	if err != nil {
+		return Response{Result: err.Error()}
+	}
+	return Response{Result: results}
+}
+
+func transitionHistory(req ApiRequest) Response {
+	transitions, err := history.Transitions(historyQuery(req))
+	if err != nil {
+		return Response{Result: err.Error()}
+	}
+	return Response{Result: transitions}
+}
+
+// copyConfigs returns a copy of powerDNSConfigs, with copies of the members
+// and their results, that can be read without holding mu.
+func copyConfigs() []DNS {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	configs := make([]DNS, len(powerDNSConfigs))
+	for i, dns := range powerDNSConfigs {
+		configs[i].Domain = dns.Domain
+		configs[i].Members = make(map[string]Member, len(dns.Members))
+		for memberName, member := range dns.Members {
+			results := make(map[string]Result, len(member.Results))
+			for checkKey, check := range member.Results {
+				results[checkKey] = check
+			}
+			member.Results = results
+			configs[i].Members[memberName] = member
+		}
+	}
+	return configs
+}
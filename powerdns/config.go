@@ -3,6 +3,7 @@ package powerdns
 import (
 	"encoding/json"
 	"fmt"
+	"ibp-geodns/config"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -10,21 +11,73 @@ import (
 	"time"
 )
 
+// loadStaticEntries fetches and verifies the static entries without holding
+// mu, which is only taken to swap them in, so a slow source does not block
+// lookups.
 func loadStaticEntries(url string) error {
+	body, err := fetchStaticEntries(url)
+	var entries map[string][]Record
+	if err == nil {
+		entries, err = parseStaticEntries(body)
+	}
+	if err != nil {
+		mu.RLock()
+		loaded := staticEntries != nil
+		mu.RUnlock()
+		if loaded {
+			return err
+		}
+
+		log.Printf("Failed to load static entries: %v, falling back to cached snapshot", err)
+		cached, snapshot, cacheErr := config.LoadSnapshot("static")
+		if cacheErr != nil {
+			return fmt.Errorf("%v (%v)", err, cacheErr)
+		}
+		entries, cacheErr := parseStaticEntries(cached)
+		if cacheErr != nil {
+			return fmt.Errorf("%v (%v)", err, cacheErr)
+		}
+		setStaticEntries(entries)
+		log.Printf("Using cached static entries snapshot from %s (%s)", snapshot.Timestamp.Format(time.RFC3339), snapshot.URL)
+		return nil
+	}
+
+	setStaticEntries(entries)
+
+	if err := config.SaveSnapshot("static", url, body); err != nil {
+		log.Printf("Failed to save static entries snapshot: %v", err)
+	}
+
+	return nil
+}
+
+func fetchStaticEntries(url string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
-		return fmt.Errorf("failed to fetch static entries: %w", err)
+		return nil, fmt.Errorf("failed to fetch static entries: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch static entries: %s", resp.Status)
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if err := config.VerifySource(url, body); err != nil {
+		return nil, err
 	}
 
+	return body, nil
+}
+
+func parseStaticEntries(body []byte) (map[string][]Record, error) {
 	var entries []Record
 	if err := json.Unmarshal(body, &entries); err != nil {
-		return fmt.Errorf("failed to unmarshal static entries: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal static entries: %w", err)
 	}
 
 	newStaticEntries := make(map[string][]Record)
@@ -33,15 +86,16 @@ func loadStaticEntries(url string) error {
 		newStaticEntries[entry.Qname] = append(newStaticEntries[entry.Qname], entry)
 	}
 
-	staticEntries = newStaticEntries
-
-	return nil
+	return newStaticEntries, nil
 }
 
-func updateStaticEntries(staticEntriesURL string) {
+func setStaticEntries(entries map[string][]Record) {
 	mu.Lock()
-	defer mu.Unlock()
+	staticEntries = entries
+	mu.Unlock()
+}
 
+func updateStaticEntries(staticEntriesURL string) {
 	err := loadStaticEntries(staticEntriesURL)
 	if err != nil {
 		log.Printf("Failed to update static entries: %v", err)
@@ -2,6 +2,7 @@ package powerdns
 
 import (
 	"fmt"
+	"ibp-geodns/config"
 	"io"
 	"log"
 	"math"
@@ -17,6 +18,12 @@ func handleLookup(params Parameters) Response {
 	defer mu.RUnlock()
 
 	domain := strings.ToLower(strings.TrimSuffix(params.Qname, "."))
+
+	start := time.Now()
+	outcome, answeredBy := "empty", ""
+	defer func() {
+		observeLookup(domain, params.Qtype, answeredBy, outcome, time.Since(start))
+	}()
 	// log.Printf("Looking up domain: %s, type: %s", domain, params.Qtype)
 
 	// Check for ACME challenge records
@@ -27,6 +34,7 @@ func handleLookup(params Parameters) Response {
 			if record.Qtype == "TXT" {
 				acmeContent, err := fetchACMEChallenge(record.Content)
 				if err == nil {
+					outcome = "acme"
 					return Response{Result: []Record{
 						{
 							Qtype:    "TXT",
@@ -53,6 +61,7 @@ func handleLookup(params Parameters) Response {
 		}
 		if len(staticRecords) > 0 {
 			// log.Printf("Found static records for domain %s: %+v", domain, staticRecords)
+			outcome = "static"
 			return Response{Result: staticRecords}
 		}
 	}
@@ -75,25 +84,29 @@ func handleLookup(params Parameters) Response {
 					DomainID: params.ZoneID,
 				}
 				records = append(records, soaRecord)
+				outcome = "soa"
 				return Response{Result: records}
 			}
 		}
 	}
 
-	var closestMember Member
-	minDistance := math.MaxFloat64
+	var closestMember, closestIPv6Member Member
+	closestMemberIPv6 := false
+	minDistance, minIPv6Distance := math.MaxFloat64, math.MaxFloat64
 
 	clientIP := params.Remote
 	clientLat, clientLon, err := getClientCoordinates(clientIP)
 	if err != nil {
 		log.Printf("Failed to get client coordinates for IP %s: %v", clientIP, err)
+		outcome = "error"
 		return Response{Result: []Record{}}
 	}
 
-	for _, config := range powerDNSConfigs {
-		if config.Domain == domain {
-			for _, member := range config.Members {
+	for _, dnsConfig := range powerDNSConfigs {
+		if dnsConfig.Domain == domain {
+			for _, member := range dnsConfig.Members {
 				success := true
+				ipv6Success := member.IPv6 != ""
 
 				// Member override is turned on, ignore member.
 				if member.Override {
@@ -107,33 +120,34 @@ func handleLookup(params Parameters) Response {
 
 				// Does member have failed checks
 				for checkName, result := range member.Results {
-					if strings.Contains(checkName, "::") {
-						var domainForCheck string
+					if result.Success {
+						continue
+					}
 
+					if strings.Contains(checkName, "::") {
 						parts := strings.SplitN(checkName, "::", 2)
-
-						if idx := strings.Index(parts[0], "/"); idx != -1 {
-							domainForCheck = parts[0][:idx]
-						} else {
-							domainForCheck = parts[0]
+						if endpointDomain(parts[0]) != domain {
+							// Check is not relevant to the current domain
+							continue
 						}
+					}
 
-						if domainForCheck == domain {
-							// This check is relevant to the current domain
-							if !result.Success {
-								success = false
-								log.Printf("Member '%s' has failed endpoint check '%s': %+v", member.MemberName, checkName, result)
-								break
-							}
+					// Failing IPv6 checks only withhold the member's AAAA record
+					if config.IsIPv6Check(checkName) {
+						if ipv6Success {
+							log.Printf("Member '%s' has failed IPv6 check '%s': %+v", member.MemberName, checkName, result)
 						}
+						ipv6Success = false
+						continue
+					}
+
+					success = false
+					if strings.Contains(checkName, "::") {
+						log.Printf("Member '%s' has failed endpoint check '%s': %+v", member.MemberName, checkName, result)
 					} else {
-						// Site-wide check
-						if !result.Success {
-							success = false
-							log.Printf("Member '%s' has failed site-wide check '%s': %+v", member.MemberName, checkName, result)
-							break
-						}
+						log.Printf("Member '%s' has failed site-wide check '%s': %+v", member.MemberName, checkName, result)
 					}
+					break
 				}
 
 				// Determine distance
@@ -142,45 +156,65 @@ func handleLookup(params Parameters) Response {
 					if dist < minDistance {
 						minDistance = dist
 						closestMember = member
+						closestMemberIPv6 = ipv6Success
+					}
+					if ipv6Success && dist < minIPv6Distance {
+						minIPv6Distance = dist
+						closestIPv6Member = member
 					}
 				}
 			}
 
-			// Deliver member IPv4 and IPv6 addresses
-			if closestMember.MemberName != "" {
-				if params.Qtype == "A" || params.Qtype == "ANY" {
-					if closestMember.IPv4 != "" {
-						records = append(records, Record{
-							Qtype:    "A",
-							Qname:    domain,
-							Content:  closestMember.IPv4,
-							Ttl:      30,
-							Auth:     true,
-							DomainID: params.ZoneID,
-						})
-					}
+			// Deliver member IPv4 addresses
+			if params.Qtype == "A" || params.Qtype == "ANY" {
+				if closestMember.MemberName != "" && closestMember.IPv4 != "" {
+					answeredBy = config.SiteKey(closestMember.MemberName, closestMember.SiteName)
+					records = append(records, Record{
+						Qtype:    "A",
+						Qname:    domain,
+						Content:  closestMember.IPv4,
+						Ttl:      30,
+						Auth:     true,
+						DomainID: params.ZoneID,
+					})
 				}
-				if params.Qtype == "AAAA" || params.Qtype == "ANY" {
-					if closestMember.IPv6 != "" {
-						records = append(records, Record{
-							Qtype:    "AAAA",
-							Qname:    domain,
-							Content:  closestMember.IPv6,
-							Ttl:      30,
-							Auth:     true,
-							DomainID: params.ZoneID,
-						})
-					}
+			}
+
+			// Deliver IPv6 addresses from the closest member whose IPv6 path is
+			// healthy. ANY answers stay on a single member, so they only carry
+			// AAAA when the IPv4 member's IPv6 is healthy as well.
+			ipv6Member := closestIPv6Member
+			if params.Qtype == "ANY" {
+				ipv6Member = Member{}
+				if closestMemberIPv6 {
+					ipv6Member = closestMember
 				}
-				break
 			}
+			if params.Qtype == "AAAA" || params.Qtype == "ANY" {
+				if ipv6Member.MemberName != "" && ipv6Member.IPv6 != "" {
+					answeredBy = config.SiteKey(ipv6Member.MemberName, ipv6Member.SiteName)
+					records = append(records, Record{
+						Qtype:    "AAAA",
+						Qname:    domain,
+						Content:  ipv6Member.IPv6,
+						Ttl:      30,
+						Auth:     true,
+						DomainID: params.ZoneID,
+					})
+				}
+			}
+			break
 		}
 	}
 
+	if len(records) > 0 {
+		outcome = "member"
+	}
+
 	// Default record if requested domain is valid (Let's be sure to not return any empty results)
 	if len(records) == 0 {
-		for _, config := range powerDNSConfigs {
-			if config.Domain == domain {
+		for _, dnsConfig := range powerDNSConfigs {
+			if dnsConfig.Domain == domain {
 				if params.Qtype == "A" || params.Qtype == "ANY" {
 					log.Printf("No records found for domain %s, returning default result", domain)
 					defaultRecord := Record{
@@ -192,6 +226,7 @@ func handleLookup(params Parameters) Response {
 						DomainID: params.ZoneID,
 					}
 					records = append(records, defaultRecord)
+					outcome = "default"
 				}
 				break
 			}
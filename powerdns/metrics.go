@@ -0,0 +1,42 @@
+package powerdns
+
+import (
+	"ibp-geodns/config"
+	"ibp-geodns/metrics"
+	"time"
+)
+
+// observeLookup counts a lookup. Names that are not served here are counted
+// as "other", so queries for random names do not create new series.
+func observeLookup(domain, qtype, member, outcome string, duration time.Duration) {
+	if !isKnownDomain(domain) {
+		domain = "other"
+	}
+	metrics.DNSLookups.WithLabelValues(domain, qtype, member, outcome).Inc()
+	metrics.DNSLookupDuration.WithLabelValues(qtype).Observe(duration.Seconds())
+}
+
+// isKnownDomain reports whether domain is served from members or static
+// entries. The caller holds mu.
+func isKnownDomain(domain string) bool {
+	if _, exists := staticEntries[domain]; exists || topLevelDomains[domain] {
+		return true
+	}
+	for _, dnsConfig := range powerDNSConfigs {
+		if dnsConfig.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// updateOverrideMetrics exports the override flag of every member site. The
+// caller holds mu.
+func updateOverrideMetrics() {
+	metrics.MemberOverride.Reset()
+	for _, dnsConfig := range powerDNSConfigs {
+		for _, member := range dnsConfig.Members {
+			metrics.MemberOverride.WithLabelValues(config.SiteKey(member.MemberName, member.SiteName)).Set(metrics.BoolValue(member.Override))
+		}
+	}
+}
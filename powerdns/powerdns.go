@@ -2,6 +2,8 @@ package powerdns
 
 import (
 	"ibp-geodns/config"
+	"ibp-geodns/consensus"
+	"ibp-geodns/metrics"
 	"log"
 	"net/http"
 	"strings"
@@ -9,13 +11,28 @@ import (
 
 var (
 	powerDNSConfigs []DNS
-	resultsChannel  chan string
+	resultsChannel  chan []config.CheckResult
 	configData      *config.Config
 	staticEntries   map[string][]Record
 	topLevelDomains map[string]bool
+	resultStats     func() config.ResultStats
 )
 
-func Init(configs []DNS, resultsCh chan string, config *config.Config) {
+// SetResultStats sets the source of the counters returned by the resultStats
+// API method.
+func SetResultStats(stats func() config.ResultStats) {
+	resultStats = stats
+}
+
+func getResultStats() config.ResultStats {
+	if resultStats == nil {
+		return config.ResultStats{}
+	}
+	return resultStats()
+}
+
+// Load prepares the lookup state without starting the updaters or the HTTP server.
+func Load(configs []DNS, config *config.Config) {
 	configData = config
 
 	err := InitGeoIP(config.GeoliteDBPath)
@@ -28,25 +45,105 @@ func Init(configs []DNS, resultsCh chan string, config *config.Config) {
 		log.Printf("Failed to load static entries: %v", err)
 	}
 
-	go startStaticEntriesUpdater(config.StaticDNSConfigUrl)
-
 	powerDNSConfigs = configs
+	topLevelDomains = buildTopLevelDomains(configs)
+	updateOverrideMetrics()
+}
+
+func Init(configs []DNS, resultsCh chan []config.CheckResult, cfg *config.Config) {
+	Load(configs, cfg)
+
+	go startStaticEntriesUpdater(cfg.StaticDNSConfigUrl)
+
 	resultsChannel = resultsCh
 
-	topLevelDomains = make(map[string]bool)
+	go updateMemberStatus()
+
+	listenAddress := cfg.ListenAddress
+	if listenAddress == "" {
+		listenAddress = ":8080"
+	}
+
+	http.HandleFunc("/dns", dnsHandler)
+	http.HandleFunc("/api", apiHandler)
+	http.HandleFunc("/status", statusOutput)
+	http.HandleFunc("/sla", slaOutput)
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/consensus", consensus.Handler())
+	http.Handle("/ingest", consensus.IngestHandler())
+	log.Printf("Starting PowerDNS server on %s", listenAddress)
+	go func() {
+		if err := http.ListenAndServe(listenAddress, nil); err != nil {
+			log.Fatalf("PowerDNS server failed: %v", err)
+		}
+	}()
+}
+
+// Lookup resolves a query the same way the PowerDNS backend does.
+func Lookup(qname, qtype, remote string) []Record {
+	response := handleLookup(Parameters{
+		Qname:  qname,
+		Qtype:  qtype,
+		Remote: remote,
+	})
+	records, _ := response.Result.([]Record)
+	return records
+}
+
+// UpdateConfigs swaps in a freshly extracted set of domains. Members that are
+// already known keep their check results and override flag, only their
+// addresses and location are refreshed. A site added to another domain takes
+// the override flag of the site.
+func UpdateConfigs(configs []DNS) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	existingMembers := make(map[string]map[string]Member)
+	overrides := make(map[string]bool)
+	for _, dnsConfig := range powerDNSConfigs {
+		existingMembers[dnsConfig.Domain] = dnsConfig.Members
+		for _, member := range dnsConfig.Members {
+			if member.Override {
+				overrides[config.SiteKey(member.MemberName, member.SiteName)] = true
+			}
+		}
+	}
+
+	for i := range configs {
+		for memberName, member := range configs[i].Members {
+			if existing, exists := existingMembers[configs[i].Domain][memberName]; exists {
+				member.Results = existing.Results
+				member.Override = existing.Override
+			} else {
+				member.Override = overrides[config.SiteKey(member.MemberName, member.SiteName)]
+				log.Printf("Adding member %s to domain %s", memberName, configs[i].Domain)
+			}
+			if member.Results == nil {
+				member.Results = make(map[string]Result)
+			}
+			configs[i].Members[memberName] = member
+		}
+		delete(existingMembers, configs[i].Domain)
+	}
+
+	for domain := range existingMembers {
+		log.Printf("Removing domain %s", domain)
+	}
+
+	powerDNSConfigs = configs
+	topLevelDomains = buildTopLevelDomains(configs)
+	pruneOtherEndpoints()
+	updateOverrideMetrics()
+}
+
+func buildTopLevelDomains(configs []DNS) map[string]bool {
+	domains := make(map[string]bool)
 	for _, config := range configs {
 		parts := strings.Split(config.Domain, ".")
 		if len(parts) > 1 {
 			topLevelDomain := strings.Join(parts[len(parts)-2:], ".")
-			topLevelDomains[topLevelDomain] = true
+			domains[topLevelDomain] = true
 		}
 	}
-
-	go updateMemberStatus()
-
-	http.HandleFunc("/dns", dnsHandler)
-	http.HandleFunc("/api", apiHandler)
-	http.HandleFunc("/status", statusOutput)
-	log.Println("Starting PowerDNS server on :8080")
-	go http.ListenAndServe(":8080", nil)
+	return domains
 }
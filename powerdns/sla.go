@@ -0,0 +1,160 @@
+package powerdns
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"ibp-geodns/report"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reportPeriod returns the period of an SLA report: a month given as YYYY-MM,
+// or from/to in RFC 3339. It defaults to the current month.
+func reportPeriod(month string, from, to time.Time) (time.Time, time.Time, error) {
+	if month != "" {
+		return report.MonthPeriod(month)
+	}
+	if from.IsZero() {
+		now := time.Now().UTC()
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return from, to, nil
+}
+
+// reportMaxGap returns how long a site may go without recorded results before
+// the time counts as unknown in SLA reports: History.MaxGap, or three times
+// the longest interval of the enabled checks and at least three minutes.
+func reportMaxGap() time.Duration {
+	if configData.History != nil && configData.History.MaxGap > 0 {
+		return time.Duration(configData.History.MaxGap) * time.Second
+	}
+	longest := 0
+	for _, check := range configData.Checks {
+		if check.Enabled == 1 && check.CheckInterval > longest {
+			longest = check.CheckInterval
+		}
+	}
+	return 3 * time.Duration(max(longest, 60)) * time.Second
+}
+
+func slaReport(req ApiRequest) Response {
+	from, to, err := reportPeriod(req.Month, req.From, req.To)
+	if err != nil {
+		return Response{Result: err.Error()}
+	}
+	sla, err := report.Generate(from, to, req.Details, reportMaxGap())
+	if err != nil {
+		return Response{Result: err.Error()}
+	}
+	return Response{Result: sla}
+}
+
+// slaOutput serves an SLA report as an HTML page, or as CSV or JSON with
+// format=csv or format=json.
+func slaOutput(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var from, to time.Time
+	var err error
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := query.Get(name); value != "" {
+			if *target, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	from, to, err = reportPeriod(query.Get("month"), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sla, err := report.Generate(from, to, query.Get("member"), reportMaxGap())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	switch query.Get("format") {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sla)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=sla-%s.csv", sla.From.Format("2006-01-02")))
+		csv.NewWriter(w).WriteAll(sla.CSV())
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(slaHTML(sla, query.Get("member"))))
+	}
+}
+
+func slaHTML(sla report.Report, memberName string) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("<!DOCTYPE html><html><head><meta charset='UTF-8'><title>%s SLA Report</title>", htmlEscape(configData.ServerName)))
+	sb.WriteString(`<style>
+		body { font-family: Arial, sans-serif; font-size: 14px; background-color: #f4f4f4; margin: 0; padding: 20px; }
+		h1, p.period { text-align: center; color: #333; }
+		table { width: 100%; border-collapse: collapse; background-color: #fff; margin-bottom: 20px; }
+		th, td { padding: 6px 10px; border: 1px solid #ddd; text-align: left; }
+		th { background-color: #34495e; color: white; }
+		tr.member td { font-weight: bold; background-color: #ecf0f1; }
+		td.scope { padding-left: 25px; color: #555; }
+		.sla-ok { color: #27ae60; }
+		.sla-degraded { color: #e67e22; }
+		.sla-failed { color: #c0392b; }
+		.sla-unknown { color: #7f8c8d; }
+	</style></head><body>`)
+	sb.WriteString(fmt.Sprintf("<h1>%s SLA Report</h1>", htmlEscape(configData.ServerName)))
+	period := url.Values{"from": {sla.From.Format(time.RFC3339)}, "to": {sla.To.Format(time.RFC3339)}}
+	if memberName != "" {
+		period.Set("member", memberName)
+	}
+	sb.WriteString(fmt.Sprintf("<p class='period'>%s to %s &middot; <a href='?format=csv&%s'>CSV</a> &middot; <a href='?format=json&%s'>JSON</a></p>",
+		sla.From.Format("2006-01-02 15:04 MST"), sla.To.Format("2006-01-02 15:04 MST"),
+		htmlEscape(period.Encode()), htmlEscape(period.Encode())))
+
+	sb.WriteString("<table><tr><th>Member / Scope</th><th>Uptime</th><th>Outages</th><th>Downtime</th><th>Excluded</th><th>Unknown</th></tr>")
+	for _, member := range sla.Members {
+		sb.WriteString("<tr class='member'>")
+		writeAvailabilityRow(&sb, member.MemberName, "", member.Overall)
+		sb.WriteString("</tr>")
+		for _, domain := range member.Domains {
+			sb.WriteString("<tr>")
+			writeAvailabilityRow(&sb, domain.Domain, "scope", domain.Availability)
+			sb.WriteString("</tr>")
+		}
+		for _, service := range member.Services {
+			sb.WriteString("<tr>")
+			writeAvailabilityRow(&sb, service.Service, "scope", service.Availability)
+			sb.WriteString("</tr>")
+		}
+	}
+	sb.WriteString("</table></body></html>")
+
+	return sb.String()
+}
+
+func writeAvailabilityRow(sb *strings.Builder, name, class string, availability report.Availability) {
+	uptimeClass, uptime := "sla-unknown", "unknown"
+	if percent := availability.UptimePercent; percent != nil {
+		uptime = fmt.Sprintf("%.3f%%", *percent)
+		if *percent < 99 {
+			uptimeClass = "sla-failed"
+		} else if *percent < 99.9 {
+			uptimeClass = "sla-degraded"
+		} else {
+			uptimeClass = "sla-ok"
+		}
+	}
+
+	sb.WriteString(fmt.Sprintf("<td class='%s'>%s</td><td class='%s'>%s</td><td>%d</td><td>%s</td><td>%s</td><td>%s</td>",
+		class, htmlEscape(name), uptimeClass, uptime, availability.Outages,
+		time.Duration(availability.DowntimeSeconds)*time.Second, time.Duration(availability.ExcludedSeconds)*time.Second,
+		time.Duration(availability.UnknownSeconds)*time.Second))
+}
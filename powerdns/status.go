@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"html"
 	"ibp-geodns/config"
+	"ibp-geodns/consensus"
+	"ibp-geodns/history"
 	"ibp-geodns/matrixbot"
 	"log"
+	"net"
 	"net/http"
 	"sort"
 	"strings"
@@ -17,171 +20,215 @@ import (
 var previousStatus = make(map[string]map[string]map[string]bool)
 var mu sync.RWMutex
 
+// pendingRecoveries holds successful results that arrived while their check
+// was held down by MinimumOfflineTime. The monitor only sends a result again
+// when it changes, so they are replayed here until the hold-down has passed.
+var pendingRecoveries = make(map[string]config.CheckResult)
+
+// updateMemberStatus applies result batches one at a time, so a slow update
+// holds back the monitor instead of racing the next batch.
 func updateMemberStatus() {
-	for result := range resultsChannel {
-		go launchUpdate(result)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case results, ok := <-resultsChannel:
+			if !ok {
+				return
+			}
+			launchUpdate(results)
+		case <-ticker.C:
+			replayPendingRecoveries()
+		}
 	}
 }
 
-func launchUpdate(result string) {
-	results := strings.Split(result, "\n")
-	for _, res := range results {
-		if strings.TrimSpace(res) == "" {
-			continue
-		}
+func recoveryKey(result config.CheckResult) string {
+	return result.MemberName + " " + result.EndpointURL + "::" + result.CheckName
+}
 
-		// Print each individual result string
-		//log.Printf("Processing result: %s", res)
+func replayPendingRecoveries() {
+	if len(pendingRecoveries) == 0 {
+		return
+	}
 
-		var siteStatus config.SiteResults
-		var endpointStatus config.EndpointResults
+	results := make([]config.CheckResult, 0, len(pendingRecoveries))
+	for _, result := range pendingRecoveries {
+		results = append(results, result)
+	}
+	launchUpdate(results)
+}
 
-		// Attempt to unmarshal as SiteResults
-		if err := json.Unmarshal([]byte(res), &siteStatus); err == nil && siteStatus.ResultType == "site" {
-			//log.Printf("Unmarshaled as SiteResults: %+v", siteStatus)
-			go updateSiteStatus(siteStatus)
-		} else if err := json.Unmarshal([]byte(res), &endpointStatus); err == nil && endpointStatus.ResultType == "endpoint" {
-			// Unmarshal as EndpointResults
-			//log.Printf("Unmarshaled as EndpointResults: %+v", endpointStatus)
-			go updateEndpointStatus(endpointStatus)
-		} else {
-			// Log the error if unmarshaling fails
-			log.Printf("Error parsing result: %v. Result data: %s", err, res)
+func launchUpdate(results []config.CheckResult) {
+	for _, result := range results {
+		delete(pendingRecoveries, recoveryKey(result))
+		switch result.ResultType {
+		case config.ResultTypeSite:
+			updateSiteStatus(result)
+		case config.ResultTypeEndpoint:
+			updateEndpointStatus(result)
+		default:
+			log.Printf("Unknown result type '%s' in result of check %s", result.ResultType, result.CheckName)
 		}
 	}
 }
 
-func updateSiteStatus(status config.SiteResults) {
-	// log.Printf("Updating site status: %+v", status)
-	for memberName, checks := range status.Members {
-		for checkName, result := range checks {
-			member, memberExists := getMember(memberName)
-			if !memberExists {
-				continue
-			}
+func updateSiteStatus(result config.CheckResult) {
+	memberName, checkName := result.MemberName, result.CheckName
 
-			if previousStatus["site"] == nil {
-				previousStatus["site"] = make(map[string]map[string]bool)
-			}
-
-			if previousStatus["site"][memberName] == nil {
-				previousStatus["site"][memberName] = make(map[string]bool)
-			}
+	member, memberExists := getMember(memberName)
+	if !memberExists {
+		return
+	}
 
-			if previousStatus["site"][memberName][checkName] != result.Success {
-				// log.Printf("Status change detected for site member %s check %s: %v -> %v", memberName, checkName, previousStatus["site"][memberName][checkName], result.Success)
+	if previousStatus["site"] == nil {
+		previousStatus["site"] = make(map[string]map[string]bool)
+	}
 
-				if result.Success {
-					if member.Results[checkName].OfflineTS.IsZero() {
-						updateMember("", memberName, checkName, Result{Success: true})
-						previousStatus["site"][memberName][checkName] = result.Success
-					} else if time.Since(member.Results[checkName].OfflineTS).Seconds() <= float64(configData.MinimumOfflineTime) {
-						continue
-					}
+	if previousStatus["site"][memberName] == nil {
+		previousStatus["site"][memberName] = make(map[string]bool)
+	}
+	_, observed := previousStatus["site"][memberName][checkName]
+
+	if previousStatus["site"][memberName][checkName] != result.Success {
+		// log.Printf("Status change detected for site member %s check %s: %v -> %v", memberName, checkName, previousStatus["site"][memberName][checkName], result.Success)
+
+		if result.Success {
+			if member.Results[checkName].OfflineTS.IsZero() {
+				updateMember("", memberName, checkName, Result{Success: true, RawSuccess: result.RawSuccess, Streak: result.Streak, CheckData: result.Data, Votes: result.Votes})
+				previousStatus["site"][memberName][checkName] = result.Success
+				recordStatusChange(result, "", observed)
+			} else if time.Since(member.Results[checkName].OfflineTS).Seconds() <= float64(configData.MinimumOfflineTime) {
+				pendingRecoveries[recoveryKey(result)] = result
+				return
+			}
 
-					if !member.Results[checkName].OfflineTS.IsZero() && time.Since(member.Results[checkName].OfflineTS).Seconds() >= float64(configData.MinimumOfflineTime) {
-						updateMember("", memberName, checkName, Result{Success: true})
-						previousStatus["site"][memberName][checkName] = result.Success
+			if !member.Results[checkName].OfflineTS.IsZero() && time.Since(member.Results[checkName].OfflineTS).Seconds() >= float64(configData.MinimumOfflineTime) {
+				updateMember("", memberName, checkName, Result{Success: true, RawSuccess: result.RawSuccess, Streak: result.Streak, CheckData: result.Data, Votes: result.Votes})
+				previousStatus["site"][memberName][checkName] = result.Success
+				recordTransition(result, "", false)
 
-						if !member.Override {
-							sendMatrixMessage(fmt.Sprintf("<b>Adding member</b> <i>%s</i> <b>to all rotations</b><br><i><b>Server:</b> %s</i><br><i><b>Check %s:</b> false -> true</i><BR><b>Result Data:</b> %v", memberName, configData.ServerName, checkName, result.CheckData))
-							logStatusChange("Site Status Change", memberName, checkName, false, true, result.CheckData)
-						}
-					}
-				} else {
-					updateMember("", memberName, checkName, Result{Success: false, Data: result.CheckError, OfflineTS: time.Now()})
-
-					previousStatus["site"][memberName][checkName] = result.Success
-					if !member.Override {
-						sendMatrixMessage(fmt.Sprintf("<b>Removing member</b> <i>%s</i> <b>from all rotations</b><br><i><b>Server:</b> %s</i><br><i><b>Check %s:</b> true -> false</i><BR><b>Result Data:</b> %v", memberName, configData.ServerName, checkName, result.CheckData))
-						logStatusChange("Site Status Change", memberName, checkName, true, false, result.CheckData)
-					}
-				}
-			} else {
-				if !result.Success {
-					updateMember("", memberName, checkName, Result{Success: false, Data: result.CheckError, OfflineTS: time.Now()})
+				if !member.Override {
+					sendMatrixMessage(fmt.Sprintf("<b>Adding member</b> <i>%s</i> <b>to all rotations</b><br><i><b>Server:</b> %s</i><br><i><b>Check %s:</b> false -> true</i><BR><b>Result Data:</b> %v", memberName, configData.ServerName, checkName, formatCheckData(result.Data)))
+					logStatusChange("Site Status Change", memberName, checkName, false, true, result.Data)
 				}
 			}
+		} else {
+			updateMember("", memberName, checkName, Result{Success: false, RawSuccess: result.RawSuccess, Streak: result.Streak, Data: result.Error, CheckData: result.Data, Votes: result.Votes, OfflineTS: time.Now()})
+
+			previousStatus["site"][memberName][checkName] = result.Success
+			recordTransition(result, "", true)
+			if !member.Override {
+				sendMatrixMessage(fmt.Sprintf("<b>Removing member</b> <i>%s</i> <b>from all rotations</b><br><i><b>Server:</b> %s</i><br><i><b>Check %s:</b> true -> false</i><BR><b>Result Data:</b> %v", memberName, configData.ServerName, checkName, formatCheckData(result.Data)))
+				logStatusChange("Site Status Change", memberName, checkName, true, false, result.Data)
+			}
+		}
+	} else {
+		if !result.Success {
+			updateMember("", memberName, checkName, Result{Success: false, RawSuccess: result.RawSuccess, Streak: result.Streak, Data: result.Error, CheckData: result.Data, Votes: result.Votes, OfflineTS: time.Now()})
+			if !observed {
+				previousStatus["site"][memberName][checkName] = result.Success
+				recordStatusChange(result, "", observed)
+			}
+		} else if current, exists := member.Results[checkName]; exists && current.Success {
+			current.RawSuccess = result.RawSuccess
+			current.Streak = result.Streak
+			current.CheckData = result.Data
+			current.Votes = result.Votes
+			updateMember("", memberName, checkName, current)
 		}
 	}
 }
 
-func updateEndpointStatus(status config.EndpointResults) {
-	//log.Printf("Updating endpoint status: %+v", status)
-	for endpointURL, members := range status.Endpoint {
-		for memberName, checks := range members {
-			for checkName, result := range checks {
-				compositeKey := fmt.Sprintf("%s::%s", endpointURL, checkName)
+func updateEndpointStatus(result config.CheckResult) {
+	endpointURL, memberName, checkName := result.EndpointURL, result.MemberName, result.CheckName
+	compositeKey := fmt.Sprintf("%s::%s", endpointURL, checkName)
 
-				if previousStatus["endpoint"] == nil {
-					previousStatus["endpoint"] = make(map[string]map[string]bool)
-				}
-				if previousStatus["endpoint"][memberName] == nil {
-					previousStatus["endpoint"][memberName] = make(map[string]bool)
-				}
+	if !isServedDomain(endpointDomain(endpointURL)) {
+		updateOtherEndpoint(result)
+		return
+	}
 
-				member, memberExists := getMember(memberName)
-				if !memberExists {
-					continue
-				}
+	if previousStatus["endpoint"] == nil {
+		previousStatus["endpoint"] = make(map[string]map[string]bool)
+	}
+	if previousStatus["endpoint"][memberName] == nil {
+		previousStatus["endpoint"][memberName] = make(map[string]bool)
+	}
+	_, observed := previousStatus["endpoint"][memberName][compositeKey]
 
-				if previousStatus["endpoint"][memberName][compositeKey] != result.Success {
-					if result.Success {
-						if member.Results[compositeKey].OfflineTS.IsZero() {
-							updateMember(endpointURL, memberName, compositeKey, Result{Success: true})
-							previousStatus["endpoint"][memberName][compositeKey] = result.Success
-						} else if time.Since(member.Results[compositeKey].OfflineTS).Seconds() <= float64(configData.MinimumOfflineTime) {
-							continue
-						}
+	member, memberExists := getMember(memberName)
+	if !memberExists {
+		return
+	}
 
-						if !member.Results[compositeKey].OfflineTS.IsZero() && time.Since(member.Results[compositeKey].OfflineTS).Seconds() >= float64(configData.MinimumOfflineTime) {
-							updateMember(endpointURL, memberName, compositeKey, Result{Success: true})
-
-							if !member.Override {
-								sendMatrixMessage(fmt.Sprintf(
-									"<b>Adding member</b> <i>%s</i> <b>to endpoint</b> <i>%s</i><br>"+
-										"<i><b>Server:</b> %s</i><br>"+
-										"<i><b>Check %s:</b> false -> true</i><br>"+
-										"<b>Result Data:</b> %v",
-									memberName, endpointURL, configData.ServerName, compositeKey, result.CheckError))
-								logStatusChange("Endpoint Status Change", memberName, compositeKey, false, true, result.CheckError)
-							}
-						}
+	if previousStatus["endpoint"][memberName][compositeKey] != result.Success {
+		if result.Success {
+			if member.Results[compositeKey].OfflineTS.IsZero() {
+				updateMember(endpointURL, memberName, compositeKey, Result{Success: true, RawSuccess: result.RawSuccess, Streak: result.Streak, CheckData: result.Data, Votes: result.Votes})
+				previousStatus["endpoint"][memberName][compositeKey] = result.Success
+				recordStatusChange(result, endpointDomain(endpointURL), observed)
+			} else if time.Since(member.Results[compositeKey].OfflineTS).Seconds() <= float64(configData.MinimumOfflineTime) {
+				pendingRecoveries[recoveryKey(result)] = result
+				return
+			}
 
-					} else {
-						updateMember(endpointURL, memberName, compositeKey, Result{Success: false, Data: result.CheckError, OfflineTS: time.Now()})
-
-						previousStatus["endpoint"][memberName][compositeKey] = result.Success
-
-						if !member.Override {
-							sendMatrixMessage(fmt.Sprintf(
-								"<b>Removing member</b> <i>%s</i> <b>from endpoint</b> <i>%s</i><br>"+
-									"<i><b>Server:</b> %s</i><br>"+
-									"<i><b>Check %s:</b> true -> false</i><br>"+
-									"<b>Result Data:</b> %v",
-								memberName, endpointURL, configData.ServerName, compositeKey, result.CheckError))
-							logStatusChange("Endpoint Status Change", memberName, compositeKey, true, false, result.CheckError)
-						}
-					}
-				} else {
-					if !result.Success && !member.Results[compositeKey].Success {
-						updateMember(endpointURL, memberName, compositeKey, Result{Success: false, Data: result.CheckError, OfflineTS: time.Now()})
-					}
+			if !member.Results[compositeKey].OfflineTS.IsZero() && time.Since(member.Results[compositeKey].OfflineTS).Seconds() >= float64(configData.MinimumOfflineTime) {
+				updateMember(endpointURL, memberName, compositeKey, Result{Success: true, RawSuccess: result.RawSuccess, Streak: result.Streak, CheckData: result.Data, Votes: result.Votes})
+				previousStatus["endpoint"][memberName][compositeKey] = result.Success
+				recordTransition(result, endpointDomain(endpointURL), false)
+
+				if !member.Override {
+					sendMatrixMessage(fmt.Sprintf(
+						"<b>Adding member</b> <i>%s</i> <b>to endpoint</b> <i>%s</i><br>"+
+							"<i><b>Server:</b> %s</i><br>"+
+							"<i><b>Check %s:</b> false -> true</i><br>"+
+							"<b>Result Data:</b> %v",
+						memberName, endpointURL, configData.ServerName, compositeKey, result.Error))
+					logStatusChange("Endpoint Status Change", memberName, compositeKey, false, true, result.Error)
 				}
 			}
+
+		} else {
+			updateMember(endpointURL, memberName, compositeKey, Result{Success: false, RawSuccess: result.RawSuccess, Streak: result.Streak, Data: result.Error, CheckData: result.Data, Votes: result.Votes, OfflineTS: time.Now()})
+
+			previousStatus["endpoint"][memberName][compositeKey] = result.Success
+			recordTransition(result, endpointDomain(endpointURL), true)
+
+			if !member.Override {
+				sendMatrixMessage(fmt.Sprintf(
+					"<b>Removing member</b> <i>%s</i> <b>from endpoint</b> <i>%s</i><br>"+
+						"<i><b>Server:</b> %s</i><br>"+
+						"<i><b>Check %s:</b> true -> false</i><br>"+
+						"<b>Result Data:</b> %v",
+					memberName, endpointURL, configData.ServerName, compositeKey, result.Error))
+				logStatusChange("Endpoint Status Change", memberName, compositeKey, true, false, result.Error)
+			}
+		}
+	} else {
+		if !result.Success && !member.Results[compositeKey].Success {
+			updateMember(endpointURL, memberName, compositeKey, Result{Success: false, RawSuccess: result.RawSuccess, Streak: result.Streak, Data: result.Error, CheckData: result.Data, Votes: result.Votes, OfflineTS: time.Now()})
+			if !observed {
+				previousStatus["endpoint"][memberName][compositeKey] = result.Success
+				recordStatusChange(result, endpointDomain(endpointURL), observed)
+			}
+		} else if current, exists := member.Results[compositeKey]; exists && result.Success && current.Success {
+			current.RawSuccess = result.RawSuccess
+			current.Streak = result.Streak
+			current.CheckData = result.Data
+			current.Votes = result.Votes
+			updateMember(endpointURL, memberName, compositeKey, current)
 		}
 	}
 }
 
 func updateMember(endpointURL, memberName, key string, result Result) {
-	if endpointURL != "" {
-		var domain string
+	mu.Lock()
+	defer mu.Unlock()
 
-		if idx := strings.Index(endpointURL, "/"); idx != -1 {
-			domain = endpointURL[:idx]
-		} else {
-			domain = endpointURL
-		}
+	if endpointURL != "" {
+		domain := endpointDomain(endpointURL)
 
 		for i := range powerDNSConfigs {
 			dnsConfig := &powerDNSConfigs[i]
@@ -203,6 +250,63 @@ func updateMember(endpointURL, memberName, key string, result Result) {
 	}
 }
 
+// otherEndpoints holds the results of endpoints outside the served domains,
+// such as bootnodes, by member. They are shown on the status page but do not
+// change answers, so they skip transitions and alerts.
+var otherEndpoints = make(map[string]map[string]Result)
+
+func isServedDomain(domain string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, dnsConfig := range powerDNSConfigs {
+		if dnsConfig.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func updateOtherEndpoint(result config.CheckResult) {
+	if _, exists := getMember(result.MemberName); !exists {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if otherEndpoints[result.MemberName] == nil {
+		otherEndpoints[result.MemberName] = make(map[string]Result)
+	}
+	key := fmt.Sprintf("%s::%s", result.EndpointURL, result.CheckName)
+	previous := otherEndpoints[result.MemberName][key]
+
+	current := Result{Success: result.Success, RawSuccess: result.RawSuccess, Streak: result.Streak, Data: result.Error, CheckData: result.Data, Votes: result.Votes}
+	if !result.Success {
+		current.OfflineTS = previous.OfflineTS
+		if current.OfflineTS.IsZero() {
+			current.OfflineTS = time.Now()
+		}
+	}
+	otherEndpoints[result.MemberName][key] = current
+}
+
+// pruneOtherEndpoints drops the results of members that are no longer
+// configured. Called with mu held.
+func pruneOtherEndpoints() {
+	for memberName := range otherEndpoints {
+		found := false
+		for _, dnsConfig := range powerDNSConfigs {
+			if _, exists := dnsConfig.Members[memberName]; exists {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(otherEndpoints, memberName)
+		}
+	}
+}
+
 func getMember(memberName string) (Member, bool) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -218,15 +322,82 @@ func getMember(memberName string) (Member, bool) {
 	return Member{}, false
 }
 
+// matrixQueue holds alerts for the Matrix worker, so a slow or unreachable
+// homeserver never holds up status updates. Alerts are dropped when it is full.
+var (
+	matrixQueue      = make(chan string, 100)
+	matrixWorkerOnce sync.Once
+)
+
 func sendMatrixMessage(message string) {
-	if configData.Matrix.Enabled == 1 {
-		bot, err := matrixbot.NewMatrixBot(configData.Matrix.HomeServerURL, configData.Matrix.Username, configData.Matrix.Password, configData.Matrix.RoomID)
-		if err != nil {
-			log.Printf("Error initializing Matrix bot: %v", err)
-		} else {
-			go bot.SendMessage(message)
+	if configData.Matrix == nil || configData.Matrix.Enabled != 1 {
+		return
+	}
+
+	matrixWorkerOnce.Do(func() {
+		go matrixWorker()
+	})
+
+	select {
+	case matrixQueue <- message:
+	default:
+		log.Printf("Matrix alert queue is full, dropping alert")
+	}
+}
+
+// matrixWorker logs in once and sends queued alerts with the same client,
+// logging in again after a failed send.
+func matrixWorker() {
+	var bot *matrixbot.MatrixBot
+	for message := range matrixQueue {
+		if bot == nil {
+			var err error
+			bot, err = matrixbot.NewMatrixBot(configData.Matrix.HomeServerURL, configData.Matrix.Username, configData.Matrix.Password, configData.Matrix.RoomID)
+			if err != nil {
+				log.Printf("Error initializing Matrix bot: %v", err)
+				continue
+			}
 		}
+		if err := bot.SendMessage(message); err != nil {
+			log.Printf("Error sending Matrix message: %v", err)
+			bot = nil
+		}
+	}
+}
+
+// recordTransition stores a change of the state served for a check in the
+// history database.
+func recordTransition(result config.CheckResult, domain string, from bool) {
+	history.RecordTransition(history.Transition{
+		Timestamp:   time.Now(),
+		MemberName:  result.MemberName,
+		Domain:      domain,
+		EndpointURL: result.EndpointURL,
+		CheckName:   result.CheckName,
+		From:        from,
+		To:          result.Success,
+		Error:       result.Error,
+	})
+}
+
+// recordStatusChange records a check entering the served state of result:
+// its first observed state, or a change from the opposite state.
+func recordStatusChange(result config.CheckResult, domain string, observed bool) {
+	if observed {
+		recordTransition(result, domain, !result.Success)
+		return
 	}
+	history.RecordTransition(history.Transition{
+		Timestamp:   time.Now(),
+		MemberName:  result.MemberName,
+		Domain:      domain,
+		EndpointURL: result.EndpointURL,
+		CheckName:   result.CheckName,
+		From:        result.Success,
+		To:          result.Success,
+		Initial:     true,
+		Error:       result.Error,
+	})
 }
 
 func logStatusChange(changeType, memberName, checkName string, prevSuccess, newSuccess bool, resultData interface{}) {
@@ -234,31 +405,15 @@ func logStatusChange(changeType, memberName, checkName string, prevSuccess, newS
 }
 
 func statusOutput(w http.ResponseWriter, r *http.Request) {
-	// Sort the powerDNSConfigs based on the domain name
-	sort.SliceStable(powerDNSConfigs, func(i, j int) bool {
-		return powerDNSConfigs[i].Domain < powerDNSConfigs[j].Domain
+	// Sort a copy of the configs based on the domain name
+	configs := copyConfigs()
+	sort.SliceStable(configs, func(i, j int) bool {
+		return configs[i].Domain < configs[j].Domain
 	})
 
-	// Sort members within each powerDNSConfig
-	for i := range powerDNSConfigs {
-		memberNames := make([]string, 0, len(powerDNSConfigs[i].Members))
-		for memberName := range powerDNSConfigs[i].Members {
-			memberNames = append(memberNames, memberName)
-		}
-		sort.Strings(memberNames)
-
-		sortedMembers := make(map[string]Member)
-		for _, memberName := range memberNames {
-			sortedMembers[memberName] = powerDNSConfigs[i].Members[memberName]
-		}
-		mu.Lock()
-		powerDNSConfigs[i].Members = sortedMembers
-		mu.Unlock()
-	}
-
 	// Collect all unique members
 	uniqueMembersMap := make(map[string]struct{})
-	for _, config := range powerDNSConfigs {
+	for _, config := range configs {
 		for memberName := range config.Members {
 			uniqueMembersMap[memberName] = struct{}{}
 		}
@@ -370,6 +525,10 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 		td {
 			font-size: 13px;
 		}
+		.config-sources {
+			margin-bottom: 20px;
+			background-color: white;
+		}
 		.result-success {
 			color: green;
 			font-weight: bold;
@@ -378,6 +537,10 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 			color: red;
 			font-weight: bold;
 		}
+		.check-data {
+			color: #666;
+			font-size: 0.9em;
+		}
 		ul {
 			margin: 0;
 			padding-left: 20px;
@@ -419,6 +582,101 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 	// Server Title
 	sb.WriteString(fmt.Sprintf("<h1>%s Status Page</h1>", htmlEscape(configData.ServerName)))
 
+	// Configuration snapshots currently in effect
+	snapshots := config.GetSnapshots()
+	snapshotNames := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		snapshotNames = append(snapshotNames, name)
+	}
+	sort.Strings(snapshotNames)
+
+	sb.WriteString("<table class='config-sources'>")
+	sb.WriteString("<tr><th>Configuration</th><th>Source</th><th>Fetched</th><th>URL</th></tr>")
+	for _, name := range snapshotNames {
+		snapshot := snapshots[name]
+		sourceClass := "result-success"
+		if snapshot.Source != config.SnapshotSourceRemote {
+			sourceClass = "result-failure"
+		}
+		sb.WriteString(fmt.Sprintf(
+			"<tr><td>%s</td><td><span class='%s'>%s</span></td><td>%s</td><td>%s</td></tr>",
+			htmlEscape(name),
+			sourceClass,
+			htmlEscape(snapshot.Source),
+			snapshot.Timestamp.Format("2006-01-02 15:04"),
+			htmlEscape(snapshot.URL),
+		))
+	}
+	sb.WriteString("</table>")
+
+	// Peers and agents voting on check results
+	if consensus.Enabled() {
+		sb.WriteString("<table class='config-sources'>")
+		sb.WriteString("<tr><th>Peer</th><th>Type</th><th>State</th><th>Last Update</th><th>Results</th><th>URL / Location</th></tr>")
+		for _, peer := range consensus.Peers() {
+			stateClass, state := "result-success", "voting"
+			if !peer.Fresh {
+				stateClass, state = "result-failure", "stale"
+			}
+			if peer.LastError != "" {
+				state = fmt.Sprintf("%s (%s)", state, peer.LastError)
+			}
+			where := peer.URL
+			if peer.Type == "agent" {
+				where = peer.Location
+			}
+			lastUpdate := "never"
+			if !peer.LastUpdate.IsZero() {
+				lastUpdate = peer.LastUpdate.Format("2006-01-02 15:04:05")
+			}
+			sb.WriteString(fmt.Sprintf(
+				"<tr><td>%s</td><td>%s</td><td><span class='%s'>%s</span></td><td>%s</td><td>%d</td><td>%s</td></tr>",
+				htmlEscape(peer.Name), peer.Type, stateClass, htmlEscape(state), lastUpdate, peer.Results, htmlEscape(where),
+			))
+		}
+		sb.WriteString("</table>")
+	}
+
+	// Endpoints outside the served domains, e.g. bootnodes
+	mu.RLock()
+	otherMembers := make([]string, 0, len(otherEndpoints))
+	for memberName := range otherEndpoints {
+		otherMembers = append(otherMembers, memberName)
+	}
+	sort.Strings(otherMembers)
+	if len(otherMembers) > 0 {
+		sb.WriteString("<table class='config-sources'>")
+		sb.WriteString("<tr><th>Member</th><th>Endpoint</th><th>Check</th><th>Result</th></tr>")
+		for _, memberName := range otherMembers {
+			keys := make([]string, 0, len(otherEndpoints[memberName]))
+			for key := range otherEndpoints[memberName] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				result := otherEndpoints[memberName][key]
+				// Split at the last separator, IPv6 hosts contain "::".
+				separator := strings.LastIndex(key, "::")
+				endpointURL, checkName := key[:separator], key[separator+2:]
+				sb.WriteString(fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%s</td><td>", htmlEscape(memberName), htmlEscape(endpointURL), htmlEscape(checkName)))
+				if result.Success {
+					sb.WriteString("<span class='result-success'>true</span>")
+				} else {
+					sb.WriteString(fmt.Sprintf("<span class='result-failure'>false (%s)</span>, %s", htmlEscape(result.Data), result.OfflineTS.Format("2006-01-02 15:04")))
+				}
+				if result.CheckData != nil {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>[%s]</span>", htmlEscape(formatCheckData(result.CheckData))))
+				}
+				if len(result.Votes) > 0 {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>{%s}</span>", htmlEscape(formatVotes(result.Votes))))
+				}
+				sb.WriteString("</td></tr>")
+			}
+		}
+		sb.WriteString("</table>")
+	}
+	mu.RUnlock()
+
 	// Render the dropdown for member filtering
 	sb.WriteString(`<select id='member-filter'>`)
 	sb.WriteString(`<option value='all'>All Members</option>`)
@@ -429,7 +687,7 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 	sb.WriteString(`</select>`)
 
 	// Iterate over each domain
-	for _, config := range powerDNSConfigs {
+	for _, config := range configs {
 		totalMembers := len(config.Members)
 		onlineMembers := 0
 		offlineMembers := 0
@@ -438,7 +696,7 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 		// Map to store each member's status within this domain
 		memberStatuses := make(map[string]string)
 
-		for _, member := range config.Members {
+		for memberKey, member := range config.Members {
 			memberOnline := true
 			for _, result := range member.Results {
 				if !result.Success || !result.OfflineTS.IsZero() {
@@ -448,10 +706,10 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 			}
 			if memberOnline {
 				onlineMembers++
-				memberStatuses[member.MemberName] = "success"
+				memberStatuses[memberKey] = "success"
 			} else {
 				offlineMembers++
-				memberStatuses[member.MemberName] = "failure"
+				memberStatuses[memberKey] = "failure"
 				domainFailed = true
 			}
 		}
@@ -523,6 +781,15 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 				if !result.OfflineTS.IsZero() {
 					sb.WriteString(fmt.Sprintf(", %s", result.OfflineTS.Format("2006-01-02 15:04")))
 				}
+				if result.RawSuccess != result.Success {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>(last %d runs: %v)</span>", result.Streak, result.RawSuccess))
+				}
+				if result.CheckData != nil {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>[%s]</span>", htmlEscape(formatCheckData(result.CheckData))))
+				}
+				if len(result.Votes) > 0 {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>{%s}</span>", htmlEscape(formatVotes(result.Votes))))
+				}
 				sb.WriteString("</li>")
 			}
 			sb.WriteString("</ul></td>")
@@ -539,6 +806,15 @@ func statusOutput(w http.ResponseWriter, r *http.Request) {
 				if !result.OfflineTS.IsZero() {
 					sb.WriteString(fmt.Sprintf(", %s", result.OfflineTS.Format("2006-01-02 15:04")))
 				}
+				if result.RawSuccess != result.Success {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>(last %d runs: %v)</span>", result.Streak, result.RawSuccess))
+				}
+				if result.CheckData != nil {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>[%s]</span>", htmlEscape(formatCheckData(result.CheckData))))
+				}
+				if len(result.Votes) > 0 {
+					sb.WriteString(fmt.Sprintf(" <span class='check-data'>{%s}</span>", htmlEscape(formatVotes(result.Votes))))
+				}
 				sb.WriteString("</li>")
 			}
 			sb.WriteString("</ul></td>")
@@ -669,21 +945,23 @@ func separateResults(domain string, results map[string]Result) (map[string]Resul
 
 			var path string
 
+			host := endpointURL
 			if idx := strings.Index(endpointURL, "/"); idx != -1 {
 				temp := strings.SplitN(endpointURL, "/", 2)
-				endpointURL = temp[0]
+				host = temp[0]
 				path = temp[1]
 			}
 
-			if endpointURL != domain {
+			if endpointDomain(endpointURL) != domain {
 				continue
 			}
 
-			var displayName string
+			displayName := checkName
+			if host != domain {
+				displayName = fmt.Sprintf("%s Port: %s", displayName, strings.TrimPrefix(host, domain+":"))
+			}
 			if path != "" {
-				displayName = fmt.Sprintf("%s Path: %s", checkName, path)
-			} else {
-				displayName = checkName
+				displayName = fmt.Sprintf("%s Path: %s", displayName, path)
 			}
 
 			endpointResults[displayName] = result
@@ -696,6 +974,57 @@ func separateResults(domain string, results map[string]Result) (map[string]Resul
 	return siteResults, endpointResults
 }
 
+// endpointDomain returns the DNS domain of an endpoint result key, which is
+// the hostname optionally followed by a port and a path.
+func endpointDomain(endpointURL string) string {
+	host := endpointURL
+	if idx := strings.Index(host, "/"); idx != -1 {
+		host = host[:idx]
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// formatCheckData renders check data as sorted key=value pairs.
+func formatCheckData(checkData interface{}) string {
+	var data map[string]interface{}
+	encoded, err := json.Marshal(checkData)
+	if err != nil || json.Unmarshal(encoded, &data) != nil {
+		return fmt.Sprintf("%v", checkData)
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, data[key]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// formatVotes renders the vote of every server on a check.
+func formatVotes(votes []config.Vote) string {
+	parts := make([]string, 0, len(votes))
+	for _, vote := range votes {
+		voter := vote.Server
+		if vote.Location != "" {
+			voter = fmt.Sprintf("%s (%s)", vote.Server, vote.Location)
+		}
+		if vote.Success {
+			parts = append(parts, fmt.Sprintf("%s: ok", voter))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: failed", voter))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
 // Helper function to escape HTML content
 func htmlEscape(s string) string {
 	return html.EscapeString(s)
@@ -1,6 +1,9 @@
 package powerdns
 
-import "time"
+import (
+	"ibp-geodns/config"
+	"time"
+)
 
 type Record struct {
 	Qtype    string `json:"qtype"`
@@ -18,6 +21,7 @@ type DNS struct {
 
 type Member struct {
 	MemberName string            `json:"member_name"`
+	SiteName   string            `json:"site_name,omitempty"`
 	IPv4       string            `json:"ipv4"`
 	IPv6       string            `json:"ipv6"`
 	Latitude   float64           `json:"latitude"`
@@ -27,15 +31,25 @@ type Member struct {
 }
 
 type Result struct {
-	Success   bool      `json:"success"`
-	Data      string    `json:"checkError"`
-	OfflineTS time.Time `json:"offline_ts,omitempty"`
+	Success    bool          `json:"success"`
+	RawSuccess bool          `json:"raw_success"`
+	Streak     int           `json:"streak"`
+	Data       string        `json:"checkError"`
+	CheckData  interface{}   `json:"checkData,omitempty"`
+	Votes      []config.Vote `json:"votes,omitempty"`
+	OfflineTS  time.Time     `json:"offline_ts,omitempty"`
 }
 
 type ApiRequest struct {
-	Method  string `json:"method"`
-	Details string `json:"details"`
-	AuthKey string `json:"authkey"`
+	Method  string    `json:"method"`
+	Details string    `json:"details"`
+	AuthKey string    `json:"authkey"`
+	Check   string    `json:"check,omitempty"`
+	Domain  string    `json:"domain,omitempty"`
+	From    time.Time `json:"from,omitempty"`
+	To      time.Time `json:"to,omitempty"`
+	Limit   int       `json:"limit,omitempty"`
+	Month   string    `json:"month,omitempty"`
 }
 
 type Request struct {
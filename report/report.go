@@ -0,0 +1,433 @@
+package report
+
+import (
+	"fmt"
+	"ibp-geodns/config"
+	"ibp-geodns/history"
+	"ibp-geodns/ibpmonitor"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seedWindow is how far around the start of a report period results are read
+// to find the state of checks without earlier transitions.
+const seedWindow = time.Hour
+
+// Availability summarizes the state of a member, or of its service on one
+// domain or service, over a report period. Time the member was overridden
+// through the API is excluded from the period and from the downtime, and so
+// are gaps in its recorded results, which count as unknown.
+// UptimePercent is nil when none of the period was measured.
+type Availability struct {
+	UptimePercent   *float64 `json:"uptime_percent"`
+	Outages         int      `json:"outages"`
+	DowntimeSeconds float64  `json:"downtime_seconds"`
+	ExcludedSeconds float64  `json:"excluded_seconds"`
+	UnknownSeconds  float64  `json:"unknown_seconds"`
+}
+
+type DomainReport struct {
+	Domain string `json:"domain"`
+	Availability
+}
+
+type ServiceReport struct {
+	Service string `json:"service"`
+	Availability
+}
+
+type MemberReport struct {
+	MemberName string          `json:"membername"`
+	Overall    Availability    `json:"overall"`
+	Domains    []DomainReport  `json:"domains"`
+	Services   []ServiceReport `json:"services"`
+}
+
+type Report struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Members []MemberReport `json:"members"`
+}
+
+type interval struct {
+	start, end time.Time
+}
+
+// series is the downtime of one check of a member, for one endpoint or for
+// the whole site.
+type series struct {
+	endpointURL string
+	domain      string
+	down        []interval
+}
+
+// MonthPeriod returns the start of a month given as YYYY-MM and the start of
+// the following month, in UTC.
+func MonthPeriod(month string) (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid month '%s', expected YYYY-MM", month)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Generate computes the availability of every active member site, or of the
+// sites of memberName, between from and to from the recorded status
+// transitions, and from the results recorded around from for checks without
+// earlier transitions. A member counts as down while any of its checks is
+// failing; IPv6 checks only affect AAAA answers and are left out. Periods
+// longer than maxGap without recorded results count as unknown.
+func Generate(from, to time.Time, memberName string, maxGap time.Duration) (Report, error) {
+	if to.IsZero() || to.After(time.Now()) {
+		to = time.Now()
+	}
+	if !from.Before(to) {
+		return Report{}, fmt.Errorf("report period is empty")
+	}
+
+	transitions, err := history.Transitions(history.Query{MemberName: memberName, To: to, Limit: -1})
+	if err != nil {
+		return Report{}, err
+	}
+	overrides, err := history.Overrides(history.Query{To: to, Limit: -1})
+	if err != nil {
+		return Report{}, err
+	}
+
+	endpoints, memberServices, serviceEndpoints := config.ExtractData()
+
+	siteKeys := make([]string, 0, len(memberServices))
+	for siteKey, memberService := range memberServices {
+		if memberName == "" || siteKey == memberName || memberService.MemberName == memberName {
+			siteKeys = append(siteKeys, siteKey)
+		}
+	}
+	sort.Strings(siteKeys)
+
+	transitionsBySite := make(map[string][]history.Transition)
+	for _, transition := range transitions {
+		if config.IsIPv6Check(transition.CheckName) {
+			continue
+		}
+		transitionsBySite[transition.MemberName] = append(transitionsBySite[transition.MemberName], transition)
+	}
+
+	report := Report{From: from, To: to, Members: []MemberReport{}}
+	for _, siteKey := range siteKeys {
+		memberService := memberServices[siteKey]
+		seeds, err := seedResults(siteKey, from, to)
+		if err != nil {
+			return Report{}, err
+		}
+		gaps, err := history.Gaps(siteKey, from, to, maxGap)
+		if err != nil {
+			return Report{}, err
+		}
+		unknown := []interval{}
+		for _, gap := range gaps {
+			unknown = appendClipped(unknown, interval{gap.From, gap.To}, from, to)
+		}
+		siteSeries := buildSeries(transitionsBySite[siteKey], seeds, from, to)
+		excluded := overrideIntervals(overrides, siteKey, memberService.MemberName, from, to)
+
+		memberReport := MemberReport{
+			MemberName: siteKey,
+			Overall:    availability(collectDowntime(siteSeries, func(series) bool { return true }), excluded, unknown, from, to),
+			Domains:    []DomainReport{},
+			Services:   []ServiceReport{},
+		}
+
+		domains := []string{}
+		for domain, members := range endpoints {
+			if _, exists := members[siteKey]; exists {
+				domains = append(domains, domain)
+			}
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			down := collectDowntime(siteSeries, func(s series) bool { return s.domain == domain })
+			memberReport.Domains = append(memberReport.Domains, DomainReport{Domain: domain, Availability: availability(down, excluded, unknown, from, to)})
+		}
+
+		for _, service := range memberService.Services {
+			serviceEndpoint, exists := serviceEndpoints[service][siteKey]
+			if !exists {
+				continue
+			}
+			keys := make(map[string]bool)
+			for _, url := range serviceEndpoint.URLs {
+				keys[ibpmonitor.EndpointKey(url.URL)] = true
+			}
+			down := collectDowntime(siteSeries, func(s series) bool { return keys[s.endpointURL] })
+			memberReport.Services = append(memberReport.Services, ServiceReport{Service: service, Availability: availability(down, excluded, unknown, from, to)})
+		}
+		sort.Slice(memberReport.Services, func(i, j int) bool {
+			return memberReport.Services[i].Service < memberReport.Services[j].Service
+		})
+
+		report.Members = append(report.Members, memberReport)
+	}
+
+	return report, nil
+}
+
+// seedResults returns, for every check of a site, the last result recorded in
+// the seed window before from, or else the first one recorded in the seed
+// window after it.
+func seedResults(siteKey string, from, to time.Time) ([]config.CheckResult, error) {
+	end := from.Add(seedWindow)
+	if end.After(to) {
+		end = to
+	}
+	results, err := history.Results(history.Query{MemberName: siteKey, From: from.Add(-seedWindow), To: end, Limit: -1})
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := make(map[string]config.CheckResult)
+	keys := []string{}
+	for _, result := range results {
+		if result.MemberName != siteKey || config.IsIPv6Check(result.CheckName) {
+			continue
+		}
+		key := result.EndpointURL + "::" + result.CheckName
+		seed, exists := seeds[key]
+		if !exists {
+			keys = append(keys, key)
+		}
+		if !exists || (seed.Timestamp.Before(from) && !result.Timestamp.After(from)) {
+			seeds[key] = result
+		}
+	}
+
+	seeded := make([]config.CheckResult, 0, len(keys))
+	for _, key := range keys {
+		seeded = append(seeded, seeds[key])
+	}
+	return seeded, nil
+}
+
+// buildSeries turns the transitions and seed results of a site into down
+// intervals per check, clipped to the period. A check has the state of its
+// latest record; gaps in the records are left to the caller.
+func buildSeries(transitions []history.Transition, seeds []config.CheckResult, from, to time.Time) []series {
+	type event struct {
+		timestamp   time.Time
+		endpointURL string
+		domain      string
+		checkName   string
+		up          bool
+	}
+	events := make([]event, 0, len(transitions)+len(seeds))
+	for _, transition := range transitions {
+		events = append(events, event{transition.Timestamp, transition.EndpointURL, transition.Domain, transition.CheckName, transition.To})
+	}
+	for _, seed := range seeds {
+		domain := ""
+		if seed.EndpointURL != "" {
+			domain = history.EndpointDomain(seed.EndpointURL)
+		}
+		events = append(events, event{seed.Timestamp, seed.EndpointURL, domain, seed.CheckName, seed.Success})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].timestamp.Before(events[j].timestamp)
+	})
+
+	bySeries := make(map[string]*series)
+	downSince := make(map[string]time.Time)
+	keys := []string{}
+	for _, e := range events {
+		key := e.endpointURL + "::" + e.checkName
+		s, exists := bySeries[key]
+		if !exists {
+			s = &series{endpointURL: e.endpointURL, domain: e.domain}
+			bySeries[key] = s
+			keys = append(keys, key)
+		}
+
+		start, isDown := downSince[key]
+		if !e.up && !isDown {
+			downSince[key] = e.timestamp
+		} else if e.up && isDown {
+			s.down = appendClipped(s.down, interval{start, e.timestamp}, from, to)
+			delete(downSince, key)
+		}
+	}
+
+	result := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := bySeries[key]
+		if start, isDown := downSince[key]; isDown {
+			s.down = appendClipped(s.down, interval{start, to}, from, to)
+		}
+		result = append(result, *s)
+	}
+	return result
+}
+
+// overrideIntervals returns the periods a site was overridden, either by its
+// site key or by its member name.
+func overrideIntervals(overrides []history.Override, siteKey, memberName string, from, to time.Time) []interval {
+	intervals := []interval{}
+	var since time.Time
+	overridden := false
+	for _, override := range overrides {
+		if override.MemberName != siteKey && override.MemberName != memberName {
+			continue
+		}
+		if override.Override && !overridden {
+			since, overridden = override.Timestamp, true
+		} else if !override.Override && overridden {
+			intervals = appendClipped(intervals, interval{since, override.Timestamp}, from, to)
+			overridden = false
+		}
+	}
+	if overridden {
+		intervals = appendClipped(intervals, interval{since, to}, from, to)
+	}
+	return intervals
+}
+
+// collectDowntime returns the down intervals of site checks and of the
+// endpoint checks selected by include.
+func collectDowntime(siteSeries []series, include func(series) bool) []interval {
+	down := []interval{}
+	for _, s := range siteSeries {
+		if s.endpointURL == "" || include(s) {
+			down = append(down, s.down...)
+		}
+	}
+	return down
+}
+
+func availability(down, excluded, unknown []interval, from, to time.Time) Availability {
+	excluded = merge(excluded)
+	unknown = subtract(merge(unknown), excluded)
+	skipped := merge(append(append([]interval{}, excluded...), unknown...))
+
+	// An outage split by an override still counts once.
+	var downtime, excludedTime, unknownTime time.Duration
+	outages := 0
+	for _, outage := range merge(down) {
+		pieces := subtract([]interval{outage}, skipped)
+		for _, i := range pieces {
+			downtime += i.end.Sub(i.start)
+		}
+		if len(pieces) > 0 {
+			outages++
+		}
+	}
+	for _, i := range excluded {
+		excludedTime += i.end.Sub(i.start)
+	}
+	for _, i := range unknown {
+		unknownTime += i.end.Sub(i.start)
+	}
+
+	var uptime *float64
+	if measured := to.Sub(from) - excludedTime - unknownTime; measured > 0 {
+		percent := 100 * (1 - downtime.Seconds()/measured.Seconds())
+		uptime = &percent
+	}
+
+	return Availability{
+		UptimePercent:   uptime,
+		Outages:         outages,
+		DowntimeSeconds: downtime.Seconds(),
+		ExcludedSeconds: excludedTime.Seconds(),
+		UnknownSeconds:  unknownTime.Seconds(),
+	}
+}
+
+func appendClipped(intervals []interval, i interval, from, to time.Time) []interval {
+	if i.start.Before(from) {
+		i.start = from
+	}
+	if i.end.After(to) {
+		i.end = to
+	}
+	if !i.start.Before(i.end) {
+		return intervals
+	}
+	return append(intervals, i)
+}
+
+// merge sorts intervals and joins overlapping ones.
+func merge(intervals []interval) []interval {
+	if len(intervals) == 0 {
+		return intervals
+	}
+	sorted := append([]interval{}, intervals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start.Before(sorted[j].start) })
+
+	merged := []interval{sorted[0]}
+	for _, i := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if !i.start.After(last.end) {
+			if i.end.After(last.end) {
+				last.end = i.end
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// subtract removes the excluded intervals from intervals.
+func subtract(intervals, excluded []interval) []interval {
+	result := []interval{}
+	for _, i := range intervals {
+		pieces := []interval{i}
+		for _, e := range excluded {
+			next := []interval{}
+			for _, p := range pieces {
+				if !e.start.Before(p.end) || !e.end.After(p.start) {
+					next = append(next, p)
+					continue
+				}
+				if p.start.Before(e.start) {
+					next = append(next, interval{p.start, e.start})
+				}
+				if e.end.Before(p.end) {
+					next = append(next, interval{e.end, p.end})
+				}
+			}
+			pieces = next
+		}
+		result = append(result, pieces...)
+	}
+	return result
+}
+
+// CSV returns the report as rows of member, scope, name, uptime percentage,
+// outages, downtime, excluded and unknown seconds, with a header row. The
+// uptime is empty when none of the period was measured.
+func (r Report) CSV() [][]string {
+	rows := [][]string{{"member", "scope", "name", "uptime_percent", "outages", "downtime_seconds", "excluded_seconds", "unknown_seconds"}}
+	row := func(member, scope, name string, a Availability) []string {
+		uptime := ""
+		if a.UptimePercent != nil {
+			uptime = fmt.Sprintf("%.4f", *a.UptimePercent)
+		}
+		return []string{
+			member, scope, name,
+			uptime,
+			fmt.Sprintf("%d", a.Outages),
+			fmt.Sprintf("%.0f", a.DowntimeSeconds),
+			fmt.Sprintf("%.0f", a.ExcludedSeconds),
+			fmt.Sprintf("%.0f", a.UnknownSeconds),
+		}
+	}
+	for _, member := range r.Members {
+		rows = append(rows, row(member.MemberName, "member", strings.SplitN(member.MemberName, "@", 2)[0], member.Overall))
+		for _, domain := range member.Domains {
+			rows = append(rows, row(member.MemberName, "domain", domain.Domain, domain.Availability))
+		}
+		for _, service := range member.Services {
+			rows = append(rows, row(member.MemberName, "service", service.Service, service.Availability))
+		}
+	}
+	return rows
+}